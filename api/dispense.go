@@ -0,0 +1,92 @@
+package powerbankSdk
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// Dispense limits: at most dispenseAttempts popups, each reply awaited for up to
+// dispenseStepTimeout. Variables so tests can shorten them.
+var (
+	dispenseAttempts    = 3
+	dispenseStepTimeout = 10 * time.Second
+)
+
+func (s *apiService) Dispense(ctx context.Context, deviceID, sn string) (*powerbankModels.DispenseResult, error) {
+	if s.shared {
+		return nil, ErrSharedSubscription
+	}
+	result := &powerbankModels.DispenseResult{}
+	for popups := 1; ; popups++ {
+		// A fresh timestamp per popup, so a retry is never mistaken for the stale original.
+		reply, published, err := s.dispenseStep(ctx, result, powerbankModels.PublishInput{
+			ClientID:    deviceID,
+			PublishType: constants.PUBLISH_TYPE_POPUP,
+			Data:        sn,
+			Timestamp:   strconv.FormatInt(time.Now().Unix(), 10),
+		})
+		if !published {
+			if popups == 1 {
+				return nil, fmt.Errorf("dispense: %w", err)
+			}
+			// The re-pop never went out, so the last check stands.
+			return result, nil
+		}
+		popup, answered := reply.(*powerbankModels.PowerBankPopupResponse)
+		if answered && popup.State == powerbankModels.PopupSuccess {
+			result.Outcome, result.Hole = powerbankModels.DispenseEjected, popup.HoleIndex
+			return result, nil
+		}
+		// Failed or unanswered: ask the cabinet where the bank is before deciding.
+		reply, _, _ = s.dispenseStep(ctx, result, powerbankModels.PublishInput{
+			ClientID:    deviceID,
+			PublishType: constants.PUBLISH_TYPE_CHECK,
+		})
+		check, ok := reply.(*powerbankModels.PowerBankCheckResponse)
+		if !ok {
+			result.Outcome = powerbankModels.DispenseUnknown
+			return result, nil
+		}
+		switch hole := check.HoleWithSN(sn); {
+		case hole != nil:
+			result.Outcome, result.Hole = powerbankModels.DispenseStillInSlot, hole.HoleIndex
+			if popups == dispenseAttempts {
+				return result, nil
+			}
+		case answered:
+			// The cabinet reported a failed popup, yet the bank is not in any slot:
+			// it may never have been in this cabinet.
+			result.Outcome = powerbankModels.DispenseUnknown
+			return result, nil
+		default:
+			// The popup went unanswered and the bank left its slot (the one a previous
+			// check found it in, if any): the 0x31 was lost.
+			result.Outcome = powerbankModels.DispenseEjected
+			return result, nil
+		}
+	}
+}
+
+// dispenseStep publishes input, awaits its reply for up to dispenseStepTimeout and
+// appends the attempt to result.
+func (s *apiService) dispenseStep(ctx context.Context, result *powerbankModels.DispenseResult, input powerbankModels.PublishInput) (reply interface{}, published bool, err error) {
+	typ, match, err := awaitedResponse(input)
+	if err != nil {
+		return nil, false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dispenseStepTimeout)
+	defer cancel()
+
+	attempt := powerbankModels.DispenseAttempt{PublishType: input.PublishType, Timestamp: input.Timestamp, SentAt: time.Now()}
+	attempt.Reply, published, attempt.Err = s.publishAndWait(ctx, input, typ, match)
+	if !published {
+		attempt.SentAt = time.Time{}
+	}
+	result.Attempts = append(result.Attempts, attempt)
+	return attempt.Reply, published, attempt.Err
+}
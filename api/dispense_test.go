@@ -0,0 +1,115 @@
+package powerbankSdk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankSimulator "github.com/techpartners-asia/powerbank/simulator"
+)
+
+func TestServerDispense(t *testing.T) {
+	defer func(d time.Duration) { dispenseStepTimeout = d }(dispenseStepTimeout)
+	dispenseStepTimeout = 300 * time.Millisecond
+
+	const sn = "85021618"
+	popup, check := constants.PUBLISH_TYPE_POPUP, constants.PUBLISH_TYPE_CHECK
+	cases := []struct {
+		name     string
+		stocked  bool
+		faults   func(sim *powerbankSimulator.Simulator)
+		outcome  powerbankModels.DispenseOutcome
+		hole     int
+		commands []constants.PUBLISH_TYPE
+	}{
+		{
+			name: "acknowledged", stocked: true,
+			outcome: powerbankModels.DispenseEjected, hole: 6,
+			commands: []constants.PUBLISH_TYPE{popup},
+		},
+		{
+			name: "reply lost after eject", stocked: true,
+			faults: func(sim *powerbankSimulator.Simulator) {
+				sim.DropNext(popup, true)
+			},
+			outcome:  powerbankModels.DispenseEjected,
+			commands: []constants.PUBLISH_TYPE{popup, check},
+		},
+		{
+			name: "lost popup re-popped", stocked: true,
+			faults: func(sim *powerbankSimulator.Simulator) {
+				sim.DropNext(popup, false)
+			},
+			outcome: powerbankModels.DispenseEjected, hole: 6,
+			commands: []constants.PUBLISH_TYPE{popup, check, popup},
+		},
+		{
+			name: "jammed", stocked: true,
+			faults: func(sim *powerbankSimulator.Simulator) {
+				for range dispenseAttempts {
+					sim.FailNext(popup, 0x11)
+				}
+			},
+			outcome: powerbankModels.DispenseStillInSlot, hole: 6,
+			commands: []constants.PUBLISH_TYPE{popup, check, popup, check, popup, check},
+		},
+		{
+			name:     "not in this cabinet",
+			outcome:  powerbankModels.DispenseUnknown,
+			commands: []constants.PUBLISH_TYPE{popup, check},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			broker := newTestBroker(t)
+			sim := newTestCabinet(t, broker)
+			if tc.stocked {
+				if err := sim.Insert(6, sn, 90); err != nil {
+					t.Fatalf("insert: %v", err)
+				}
+			}
+			svc := newTestServer(t, broker, powerbankModels.ServerInput{})
+			if tc.faults != nil {
+				tc.faults(sim)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			res, err := svc.Dispense(ctx, testDeviceID, sn)
+			if err != nil {
+				t.Fatalf("Dispense: %v", err)
+			}
+			if res.Outcome != tc.outcome || res.Hole != tc.hole {
+				t.Errorf("outcome %v hole %d, want %v hole %d", res.Outcome, res.Hole, tc.outcome, tc.hole)
+			}
+			var commands []constants.PUBLISH_TYPE
+			for _, a := range res.Attempts {
+				commands = append(commands, a.PublishType)
+				if a.SentAt.IsZero() || (a.PublishType == popup) != (a.Timestamp != "") {
+					t.Errorf("attempt %+v", a)
+				}
+			}
+			if len(commands) != len(tc.commands) {
+				t.Fatalf("commands %v, want %v", commands, tc.commands)
+			}
+			for i := range commands {
+				if commands[i] != tc.commands[i] {
+					t.Fatalf("commands %v, want %v", commands, tc.commands)
+				}
+			}
+		})
+	}
+}
+
+func TestServerDispenseNotPublished(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res, err := svc.Dispense(ctx, testDeviceID, "85021618"); err == nil {
+		t.Errorf("Dispense with a done ctx: got %+v, want error", res)
+	}
+}
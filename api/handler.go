@@ -0,0 +1,86 @@
+package powerbankSdk
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// callbackHandler adapts the function-style ServerInput.CallbackSubscribe,
+// CallbackHeartbeat and CallbackParseError to Handler.
+type callbackHandler struct {
+	subscribe  func(typ constants.PUBLISH_TYPE, clientID string, msg interface{})
+	heartbeat  func(deviceID string, msg *powerbankModels.PowerBankHealthCheckResponse, receivedAt time.Time)
+	parseError func(deviceID, topic string, payload []byte, err error)
+}
+
+// newHandler returns input.Handler, or an adapter around the callbacks when it is nil.
+func newHandler(input powerbankModels.ServerInput) (powerbankModels.Handler, error) {
+	if input.Handler != nil {
+		if input.CallbackSubscribe != nil || input.CallbackHeartbeat != nil || input.CallbackParseError != nil {
+			return nil, fmt.Errorf("set either Handler or the Callback* functions, not both")
+		}
+		return input.Handler, nil
+	}
+	return &callbackHandler{
+		subscribe:  input.CallbackSubscribe,
+		heartbeat:  input.CallbackHeartbeat,
+		parseError: input.CallbackParseError,
+	}, nil
+}
+
+func (h *callbackHandler) emit(typ constants.PUBLISH_TYPE, deviceID string, msg interface{}) {
+	if h.subscribe != nil {
+		h.subscribe(typ, deviceID, msg)
+	}
+}
+
+func (h *callbackHandler) OnCheck(deviceID string, msg *powerbankModels.PowerBankCheckResponse) {
+	h.emit(constants.PUBLISH_TYPE_CHECK, deviceID, msg)
+}
+
+func (h *callbackHandler) OnPopupBySN(deviceID string, msg *powerbankModels.PowerBankPopupResponse) {
+	h.emit(constants.PUBLISH_TYPE_POPUP, deviceID, msg)
+}
+
+func (h *callbackHandler) OnPopupByHole(deviceID string, msg *powerbankModels.PowerBankPopupByHoleResponse) {
+	h.emit(constants.PUBLISH_TYPE_POPUP_BY_HOLE, deviceID, msg)
+}
+
+func (h *callbackHandler) OnReturn(deviceID string, msg *powerbankModels.PowerBankReturnResponse) {
+	h.emit(constants.PUBLISH_TYPE_RETURN, deviceID, msg)
+}
+
+func (h *callbackHandler) OnReturnFix(deviceID string, msg *powerbankModels.PowerBankReturnFixResponse) {
+	h.emit(constants.PUBLISH_TYPE_RETURN_FIX, deviceID, msg)
+}
+
+func (h *callbackHandler) OnHeartbeat(deviceID string, msg *powerbankModels.PowerBankHealthCheckResponse, receivedAt time.Time) {
+	if h.heartbeat != nil {
+		h.heartbeat(deviceID, msg, receivedAt)
+	}
+}
+
+func (h *callbackHandler) OnParseError(deviceID, topic string, payload []byte, err error) {
+	if h.parseError != nil {
+		h.parseError(deviceID, topic, payload, err)
+	}
+}
+
+// dispatch hands a frame decoded by ParseResponse to the matching Handler method.
+func dispatch(h powerbankModels.Handler, deviceID string, msg interface{}) {
+	switch m := msg.(type) {
+	case *powerbankModels.PowerBankCheckResponse:
+		h.OnCheck(deviceID, m)
+	case *powerbankModels.PowerBankPopupResponse:
+		h.OnPopupBySN(deviceID, m)
+	case *powerbankModels.PowerBankPopupByHoleResponse:
+		h.OnPopupByHole(deviceID, m)
+	case *powerbankModels.PowerBankReturnResponse:
+		h.OnReturn(deviceID, m)
+	case *powerbankModels.PowerBankReturnFixResponse:
+		h.OnReturnFix(deviceID, m)
+	}
+}
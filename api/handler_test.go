@@ -0,0 +1,137 @@
+package powerbankSdk
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankUtils "github.com/techpartners-asia/powerbank/utils"
+)
+
+// returnHandler records 0x40 frames and ignores everything else.
+type returnHandler struct {
+	powerbankModels.NopHandler
+	returns chan *powerbankModels.PowerBankReturnResponse
+}
+
+func (h *returnHandler) OnReturn(deviceID string, msg *powerbankModels.PowerBankReturnResponse) {
+	if deviceID == testDeviceID {
+		h.returns <- msg
+	}
+}
+
+func TestServerHandlerReceivesTypedReturn(t *testing.T) {
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+	h := &returnHandler{returns: make(chan *powerbankModels.PowerBankReturnResponse, 1)}
+	newTestServer(t, broker, powerbankModels.ServerInput{Handler: h})
+
+	if err := sim.InjectReturn(3, "85021618", 75); err != nil {
+		t.Fatalf("inject return: %v", err)
+	}
+	select {
+	case rt := <-h.returns:
+		if rt.HoleIndex != 3 || rt.PowerbankSN != "85021618" || rt.SOC != 75 {
+			t.Errorf("return: %+v", rt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("return never reached OnReturn")
+	}
+}
+
+func TestNewHandlerRejectsHandlerAndCallbacks(t *testing.T) {
+	_, err := newHandler(powerbankModels.ServerInput{
+		Handler:           powerbankModels.NopHandler{},
+		CallbackSubscribe: func(constants.PUBLISH_TYPE, string, interface{}) {},
+	})
+	if err == nil {
+		t.Fatal("expected an error when both Handler and CallbackSubscribe are set")
+	}
+}
+
+type parseFailure struct {
+	deviceID, topic string
+	payload         []byte
+	err             error
+}
+
+// TestServerReportsUndecodableFrames injects frames the SDK cannot decode on the update
+// and heart topics and checks each reaches CallbackParseError with its raw bytes.
+func TestServerReportsUndecodableFrames(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker)
+	failures := make(chan parseFailure, 4)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{
+		CallbackParseError: func(deviceID, topic string, payload []byte, err error) {
+			failures <- parseFailure{deviceID, topic, payload, err}
+		},
+	})
+	awaitReady(t, svc) // the heart topic too
+
+	unknown := []byte{0xA8, 0x00, 0x05, 0xAB, 0xA8}
+	badHeart := []byte{0xA8, 0x00, 0x09, 0x7A, 0x10, 0x00, 0x00, 0x00, 0x00}
+	cases := []struct {
+		topic, deviceID string
+		payload         []byte
+		want            error
+	}{
+		{"/powerbank/" + testDeviceID + "/user/update", testDeviceID, unknown, powerbankUtils.ErrUnknownCommand},
+		{"/powerbank/" + testDeviceID + "/user/heart", testDeviceID, badHeart, powerbankUtils.ErrChecksumMismatch},
+		{"/powerbank//user/update", "", unknown, ErrNoDeviceID},
+	}
+	for _, tc := range cases {
+		if err := broker.Publish(tc.topic, tc.payload); err != nil {
+			t.Fatalf("inject %s: %v", tc.topic, err)
+		}
+		select {
+		case f := <-failures:
+			if f.deviceID != tc.deviceID || f.topic != tc.topic || !bytes.Equal(f.payload, tc.payload) || !errors.Is(f.err, tc.want) {
+				t.Errorf("%s: got %+v, want device %q and %v", tc.topic, f, tc.deviceID, tc.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: frame never reached CallbackParseError", tc.topic)
+		}
+	}
+}
+
+// TestServerLenientChecksumIsPerServer runs a lenient and a strict server side by side:
+// a heart frame with a bad check code reaches the lenient one's heartbeat callback and
+// the strict one's parse error callback.
+func TestServerLenientChecksumIsPerServer(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker)
+	beats := make(chan *powerbankModels.PowerBankHealthCheckResponse, 1)
+	lenient := newTestServer(t, broker, powerbankModels.ServerInput{
+		LenientChecksum: true,
+		CallbackHeartbeat: func(_ string, msg *powerbankModels.PowerBankHealthCheckResponse, _ time.Time) {
+			beats <- msg
+		},
+	})
+	failures := make(chan error, 1)
+	strict := newTestServer(t, broker, powerbankModels.ServerInput{
+		CallbackParseError: func(_, _ string, _ []byte, err error) { failures <- err },
+	})
+	awaitReady(t, lenient)
+	awaitReady(t, strict)
+
+	badHeart := []byte{0xA8, 0x00, 0x09, 0x7A, 0x10, 0x00, 0x00, 0x00, 0x00}
+	if err := broker.Publish("/powerbank/"+testDeviceID+"/user/heart", badHeart); err != nil {
+		t.Fatalf("inject heart: %v", err)
+	}
+	select {
+	case <-beats:
+	case <-time.After(5 * time.Second):
+		t.Error("lenient server: heart frame never reached CallbackHeartbeat")
+	}
+	select {
+	case err := <-failures:
+		if !errors.Is(err, powerbankUtils.ErrChecksumMismatch) {
+			t.Errorf("strict server: got %v, want ErrChecksumMismatch", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("strict server: heart frame never reached CallbackParseError")
+	}
+}
@@ -0,0 +1,196 @@
+package powerbankSdk
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// Errors returned (wrapped) by Publish for a dispense whose IdempotencyKey was seen before.
+var (
+	ErrDispenseInFlight = errors.New("dispense with this idempotency key is in flight")
+	ErrAlreadyDispensed = errors.New("dispense with this idempotency key already ejected a bank")
+)
+
+// ErrIdempotencyStoreFull is returned by MemoryIdempotencyStore.Reserve when every key
+// it holds is still in flight.
+var ErrIdempotencyStoreFull = errors.New("idempotency store full of in-flight keys")
+
+// defaultIdempotencyKeys bounds the default in-memory IdempotencyStore.
+const defaultIdempotencyKeys = 10000
+
+// dispenseTimeout is how long a keyed dispense waits for its 0x31/0x21 reply. A
+// variable so tests can shorten it.
+var dispenseTimeout = defaultAwaitTimeout
+
+// dispenseKeyTTL is how long a key stays in flight when no reply arrives: the bank may
+// have come out, so a retry is refused until a check can tell. Documented on
+// PublishInput.IdempotencyKey. A variable so tests can shorten it.
+var dispenseKeyTTL = 10 * time.Minute
+
+// MemoryIdempotencyStore is an in-process IdempotencyStore holding at most capacity
+// keys; when full, a lapsed reservation or the oldest completed key makes room. A live
+// reservation is never dropped: Reserve returns ErrIdempotencyStoreFull instead.
+type MemoryIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	keys     map[string]*list.Element // of *idempotencyEntry
+	order    *list.List               // oldest first
+}
+
+type idempotencyEntry struct {
+	key     string
+	state   powerbankModels.IdempotencyState
+	expires time.Time // in-flight reservations lapse; zero once completed
+}
+
+// NewMemoryIdempotencyStore returns an empty store for at most capacity keys.
+func NewMemoryIdempotencyStore(capacity int) *MemoryIdempotencyStore {
+	if capacity <= 0 {
+		capacity = defaultIdempotencyKeys
+	}
+	return &MemoryIdempotencyStore{capacity: capacity, keys: make(map[string]*list.Element), order: list.New()}
+}
+
+func (m *MemoryIdempotencyStore) Reserve(_ context.Context, key string, ttl time.Duration) (powerbankModels.IdempotencyState, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if el, ok := m.keys[key]; ok {
+		e := el.Value.(*idempotencyEntry)
+		if e.state == powerbankModels.IdempotencyCompleted || now.Before(e.expires) {
+			return e.state, false, nil
+		}
+		m.remove(el)
+	}
+	if len(m.keys) >= m.capacity && !m.evict(now) {
+		return 0, false, ErrIdempotencyStoreFull
+	}
+	m.keys[key] = m.order.PushBack(&idempotencyEntry{key: key, state: powerbankModels.IdempotencyInFlight, expires: now.Add(ttl)})
+	return powerbankModels.IdempotencyInFlight, true, nil
+}
+
+func (m *MemoryIdempotencyStore) Complete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.keys[key]
+	if !ok {
+		// Lapsed meanwhile; record it anyway, the bank is out, even above capacity.
+		if len(m.keys) >= m.capacity {
+			m.evict(time.Now())
+		}
+		el = m.order.PushBack(&idempotencyEntry{key: key})
+		m.keys[key] = el
+	}
+	e := el.Value.(*idempotencyEntry)
+	e.state = powerbankModels.IdempotencyCompleted
+	e.expires = time.Time{}
+	m.order.MoveToBack(el)
+	return nil
+}
+
+func (m *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.keys[key]; ok && el.Value.(*idempotencyEntry).state == powerbankModels.IdempotencyInFlight {
+		m.remove(el)
+	}
+	return nil
+}
+
+// evict drops a lapsed reservation, else the oldest completed key. It reports false
+// when every key is a live reservation.
+func (m *MemoryIdempotencyStore) evict(now time.Time) bool {
+	var completed *list.Element
+	for el := m.order.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*idempotencyEntry)
+		if e.state == powerbankModels.IdempotencyInFlight && !now.Before(e.expires) {
+			m.remove(el)
+			return true
+		}
+		if completed == nil && e.state == powerbankModels.IdempotencyCompleted {
+			completed = el
+		}
+	}
+	if completed == nil {
+		return false
+	}
+	m.remove(completed)
+	return true
+}
+
+func (m *MemoryIdempotencyStore) remove(el *list.Element) {
+	delete(m.keys, el.Value.(*idempotencyEntry).key)
+	m.order.Remove(el)
+}
+
+// reserveDispense claims input.IdempotencyKey and watches for the dispense's reply in
+// the background: a successful 0x31/0x21 completes the key and a failed one releases
+// it. With no reply within dispenseTimeout the outcome is unknown, so the key stays in
+// flight until dispenseKeyTTL lapses. Call the returned abort if the popup was
+// certainly not published, to release the key at once.
+func (s *apiService) reserveDispense(ctx context.Context, input powerbankModels.PublishInput) (abort func(), err error) {
+	typ, match, err := awaitedResponse(input)
+	if err != nil {
+		return nil, err
+	}
+	key, timeout := input.IdempotencyKey, dispenseTimeout
+	state, ok, err := s.keys.Reserve(ctx, key, max(dispenseKeyTTL, timeout))
+	if err != nil {
+		return nil, fmt.Errorf("idempotency store: %w", err)
+	}
+	if !ok {
+		if state == powerbankModels.IdempotencyCompleted {
+			return nil, fmt.Errorf("key %q: %w", key, ErrAlreadyDispensed)
+		}
+		return nil, fmt.Errorf("key %q: %w", key, ErrDispenseInFlight)
+	}
+
+	// Register before publishing: a fast cabinet can answer before Publish returns.
+	req := s.pending.add(input.ClientID, typ, match)
+	aborted := make(chan struct{})
+	go func() {
+		defer s.pending.remove(input.ClientID, typ, req)
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		ejected := false
+		select {
+		case res := <-req.ch:
+			ejected = popupEjected(res)
+		case <-aborted:
+		case <-timer.C:
+			return // leave the key to lapse
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+		defer cancel()
+		var err error
+		if ejected {
+			err = s.keys.Complete(ctx, key)
+		} else {
+			err = s.keys.Release(ctx, key)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[powerbank-sdk] idempotency store: settle key %q: %v\n", key, err)
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(aborted) }) }, nil
+}
+
+// popupEjected reports whether a 0x31/0x21 reply says the bank came out.
+func popupEjected(res interface{}) bool {
+	switch r := res.(type) {
+	case *powerbankModels.PowerBankPopupResponse:
+		return r.State == powerbankModels.PopupSuccess
+	case *powerbankModels.PowerBankPopupByHoleResponse:
+		return r.State == powerbankModels.PopupSuccess
+	}
+	return false
+}
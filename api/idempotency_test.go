@@ -0,0 +1,199 @@
+package powerbankSdk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// publishUntil repeats publish until it returns want (nil or an error matched with
+// errors.Is), e.g. until a key's reply has settled it.
+func publishUntil(t *testing.T, publish func() error, want error) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := publish()
+		if (want == nil && err == nil) || (want != nil && errors.Is(err, want)) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v, want %v", err, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerIdempotencyKey(t *testing.T) {
+	defer func(d, ttl time.Duration) { dispenseTimeout, dispenseKeyTTL = d, ttl }(dispenseTimeout, dispenseKeyTTL)
+	dispenseTimeout, dispenseKeyTTL = 300*time.Millisecond, time.Second
+
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+	for hole, sn := range map[int]string{1: "85021618", 2: "85019121"} {
+		if err := sim.Insert(hole, sn, 90); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{})
+	popup := func(key, sn string) func() error {
+		return func() error {
+			return svc.Publish(powerbankModels.PublishInput{
+				ClientID:       testDeviceID,
+				PublishType:    constants.PUBLISH_TYPE_POPUP,
+				Data:           sn,
+				IdempotencyKey: key,
+			})
+		}
+	}
+
+	// In flight until the 0x31 arrives, then refused for good.
+	sim.SetDelay(200 * time.Millisecond)
+	if err := popup("rental-1", "85021618")(); err != nil {
+		t.Fatalf("first dispense: %v", err)
+	}
+	if err := popup("rental-1", "85021618")(); !errors.Is(err, ErrDispenseInFlight) {
+		t.Fatalf("retry in flight: got %v, want ErrDispenseInFlight", err)
+	}
+	publishUntil(t, popup("rental-1", "85021618"), ErrAlreadyDispensed)
+	if got := sim.Snapshot().ControlBoards[0].Holes[0].PowerbankSN; got == "85021618" {
+		t.Errorf("slot 1 after one keyed dispense: %q", got)
+	}
+
+	// A failed popup releases its key for a retry.
+	sim.FailNext(constants.PUBLISH_TYPE_POPUP, 0x11)
+	if err := popup("rental-2", "85019121")(); err != nil {
+		t.Fatalf("failing dispense: %v", err)
+	}
+	publishUntil(t, popup("rental-2", "85019121"), nil)
+	publishUntil(t, popup("rental-2", "85019121"), ErrAlreadyDispensed)
+
+	// A lost reply leaves the outcome unknown: the key stays in flight past
+	// dispenseTimeout, until dispenseKeyTTL.
+	sim.SetDelay(0)
+	sim.DropNext(constants.PUBLISH_TYPE_POPUP, false)
+	reserved := time.Now()
+	if err := popup("rental-3", "85000000")(); err != nil {
+		t.Fatalf("unanswered dispense: %v", err)
+	}
+	time.Sleep(2 * dispenseTimeout)
+	if err := popup("rental-3", "85000000")(); !errors.Is(err, ErrDispenseInFlight) {
+		t.Fatalf("retry after the timeout: got %v, want ErrDispenseInFlight", err)
+	}
+	time.Sleep(time.Until(reserved.Add(dispenseKeyTTL - 100*time.Millisecond)))
+	if err := popup("rental-3", "85000000")(); !errors.Is(err, ErrDispenseInFlight) {
+		t.Fatalf("retry just before dispenseKeyTTL: got %v, want ErrDispenseInFlight", err)
+	}
+	publishUntil(t, popup("rental-3", "85000000"), nil)
+	if locked := time.Since(reserved); locked < dispenseKeyTTL {
+		t.Errorf("key released after %v, want dispenseKeyTTL (%v)", locked, dispenseKeyTTL)
+	}
+
+	// Keys do not apply to other commands.
+	check := powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_CHECK, IdempotencyKey: "rental-1"}
+	if err := svc.Publish(check); err != nil {
+		t.Errorf("check with a used key: %v", err)
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIdempotencyStore(2)
+	reserve := func(key string, ttl time.Duration) (powerbankModels.IdempotencyState, bool) {
+		t.Helper()
+		state, ok, err := store.Reserve(ctx, key, ttl)
+		if err != nil {
+			t.Fatalf("reserve %s: %v", key, err)
+		}
+		return state, ok
+	}
+
+	if _, ok := reserve("a", time.Hour); !ok {
+		t.Fatal("reserve a: refused")
+	}
+	if state, ok := reserve("a", time.Hour); ok || state != powerbankModels.IdempotencyInFlight {
+		t.Errorf("reserve a again: got (%v, %v), want in flight", state, ok)
+	}
+	_ = store.Complete(ctx, "a")
+	_ = store.Release(ctx, "a") // no effect once completed
+	if state, ok := reserve("a", time.Hour); ok || state != powerbankModels.IdempotencyCompleted {
+		t.Errorf("reserve completed a: got (%v, %v), want completed", state, ok)
+	}
+
+	// A lapsed reservation can be taken again.
+	reserve("b", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := reserve("b", time.Hour); !ok {
+		t.Error("reserve lapsed b: refused")
+	}
+
+	// At capacity the oldest completed key makes room before an in-flight one.
+	reserve("c", time.Hour)
+	if state, ok := reserve("b", time.Hour); ok || state != powerbankModels.IdempotencyInFlight {
+		t.Errorf("b: got (%v, %v), want still in flight", state, ok)
+	}
+
+	// Full of live reservations: refuse rather than drop one.
+	if _, _, err := store.Reserve(ctx, "d", time.Hour); !errors.Is(err, ErrIdempotencyStoreFull) {
+		t.Errorf("reserve d when full: got %v, want ErrIdempotencyStoreFull", err)
+	}
+	if state, ok := reserve("b", time.Hour); ok || state != powerbankModels.IdempotencyInFlight {
+		t.Errorf("b after a refused reserve: got (%v, %v), want still in flight", state, ok)
+	}
+
+	_ = store.Complete(ctx, "c")
+	if _, ok := reserve("a", time.Hour); !ok {
+		t.Error("a was not evicted to make room for c")
+	}
+}
+
+// TestServerIdempotencyKeyAcrossReplicas runs two replicas on one store: a retry on the
+// other replica is refused too. A replica in a shared group refuses keys outright, as
+// it may never see the reply that settles them.
+func TestServerIdempotencyKeyAcrossReplicas(t *testing.T) {
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+	if err := sim.Insert(1, "85021618", 90); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	// Connect every replica before any traffic: NewServer sets package-level parser
+	// options that the others' receive goroutines read.
+	store := NewMemoryIdempotencyStore(0)
+	connect := func(group string) ApiService {
+		svc, err := NewServer(powerbankModels.ServerInput{
+			Host:             broker.Host(),
+			Port:             broker.Port(),
+			SharedGroup:      group,
+			IdempotencyStore: store,
+		})
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		t.Cleanup(svc.Disconnect)
+		return svc
+	}
+	a, b, shared := connect(""), connect(""), connect("billing")
+	awaitCheck(t, a, powerbankModels.PublishInput{ClientID: testDeviceID})
+	awaitCheck(t, b, powerbankModels.PublishInput{ClientID: testDeviceID})
+	popup := func(svc ApiService) func() error {
+		return func() error {
+			return svc.Publish(powerbankModels.PublishInput{
+				ClientID:       testDeviceID,
+				PublishType:    constants.PUBLISH_TYPE_POPUP,
+				Data:           "85021618",
+				IdempotencyKey: "rental-1",
+			})
+		}
+	}
+
+	if err := popup(a)(); err != nil {
+		t.Fatalf("dispense on a: %v", err)
+	}
+	publishUntil(t, popup(b), ErrAlreadyDispensed)
+	if err := popup(shared)(); !errors.Is(err, ErrSharedSubscription) {
+		t.Errorf("keyed dispense in a shared group: got %v, want ErrSharedSubscription", err)
+	}
+}
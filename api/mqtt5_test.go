@@ -0,0 +1,175 @@
+package powerbankSdk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankMqttTest "github.com/techpartners-asia/powerbank/mqtttest"
+)
+
+// TestServerMQTT5 drives the MQTT 5 client against the (MQTT 3.1.1) simulated cabinet
+// and checks the publish properties reach the broker.
+func TestServerMQTT5(t *testing.T) {
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+	if err := sim.Insert(3, "85021618", 90); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	sent := make(chan powerbankMqttTest.Message, 16)
+	if err := broker.Subscribe("/powerbank/+/user/get", func(m powerbankMqttTest.Message) { sent <- m }); err != nil {
+		t.Fatalf("broker subscribe: %v", err)
+	}
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{ProtocolVersion: 5})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := svc.PublishAndWait(ctx, powerbankModels.PublishInput{
+		ClientID:        testDeviceID,
+		PublishType:     constants.PUBLISH_TYPE_POPUP,
+		Data:            "85021618",
+		UserProperties:  map[string]string{"order": "A-17", "tenant": "ub"},
+		ResponseTopic:   "billing/replies",
+		CorrelationData: []byte("req-42"),
+	})
+	if err != nil {
+		t.Fatalf("PublishAndWait: %v", err)
+	}
+	if popup, ok := res.(*powerbankModels.PowerBankPopupResponse); !ok || popup.HoleIndex != 3 {
+		t.Fatalf("popup reply: %#v", res)
+	}
+
+	for {
+		select {
+		case m := <-sent:
+			if !bytes.Contains(m.Payload, []byte(`"popup_sn"`)) {
+				// A readiness check: idempotent, so QoS 1 for a PUBACK reason code.
+				if m.QoS != 1 {
+					t.Errorf("check sent at QoS %d, want 1", m.QoS)
+				}
+				continue
+			}
+			if m.QoS != 0 || m.UserProperties["order"] != "A-17" || m.UserProperties["tenant"] != "ub" ||
+				m.ResponseTopic != "billing/replies" || string(m.CorrelationData) != "req-42" {
+				t.Errorf("popup on the wire: %+v", m)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("popup never reached the broker")
+		}
+	}
+}
+
+func TestServerMQTT5PubackReasonCode(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{ProtocolVersion: 5})
+
+	broker.DenyPublish("/powerbank/" + testDeviceID + "/user/get")
+	err := svc.Publish(powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_CHECK})
+	var rc *ReasonCodeError
+	if !errors.As(err, &rc) || rc.Packet != "PUBACK" || rc.Code != 0x87 {
+		t.Fatalf("got %v, want PUBACK reason code 0x87", err)
+	}
+}
+
+func TestServerMQTT5RejectedSubscription(t *testing.T) {
+	defer func(d time.Duration) { subscribeBackoff = d }(subscribeBackoff)
+	subscribeBackoff = 20 * time.Millisecond
+
+	broker := newTestBroker(t)
+	broker.DenySubscribe("/powerbank/+/user/heart", 0)
+	newTestCabinet(t, broker)
+	failures := make(chan error, 1)
+	newTestServer(t, broker, powerbankModels.ServerInput{
+		ProtocolVersion:  5,
+		OnSubscribeError: func(err error) { failures <- err },
+	})
+
+	select {
+	case err := <-failures:
+		var rc *ReasonCodeError
+		if !errors.Is(err, ErrSubscriptionRejected) || !errors.As(err, &rc) || rc.Packet != "SUBACK" || rc.Code < 0x80 {
+			t.Errorf("OnSubscribeError: %v, want a SUBACK failure reason code", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnSubscribeError never ran")
+	}
+}
+
+func TestServerMQTT5Reconnects(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker)
+	lost := make(chan error, 1)
+	reconnecting := make(chan struct{}, 1)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{
+		ProtocolVersion:  5,
+		OnConnectionLost: func(err error) { lost <- err },
+		OnReconnecting: func() {
+			select {
+			case reconnecting <- struct{}{}:
+			default:
+			}
+		},
+	})
+
+	broker.Close()
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnConnectionLost never ran")
+	}
+	if st := svc.Status(); st.Connected || st.LastError == nil {
+		t.Errorf("status after loss: %+v", st)
+	}
+	select {
+	case <-reconnecting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnReconnecting never ran")
+	}
+}
+
+func TestServerMQTT5Options(t *testing.T) {
+	broker := newTestBroker(t)
+	if _, err := NewServer(powerbankModels.ServerInput{Host: broker.Host(), Port: broker.Port(), ProtocolVersion: 3}); err == nil {
+		t.Error("ProtocolVersion 3: expected error")
+	}
+	down := newTestBroker(t)
+	down.Close()
+	if _, err := NewServer(powerbankModels.ServerInput{Host: down.Host(), Port: down.Port(), ProtocolVersion: 5}); err == nil {
+		t.Error("MQTT 5 to an unreachable broker: expected error")
+	}
+
+	svc, err := NewServer(powerbankModels.ServerInput{Host: broker.Host(), Port: broker.Port()})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer svc.Disconnect()
+	err = svc.Publish(powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_CHECK, ResponseTopic: "replies"})
+	if !errors.Is(err, ErrRequiresMQTT5) {
+		t.Errorf("MQTT 5 property over 3.1.1: got %v, want ErrRequiresMQTT5", err)
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"/powerbank/+/user/update", "/powerbank/864601068412899/user/update", true},
+		{"/powerbank/+/user/update", "/powerbank/864601068412899/user/heart", false},
+		{"/powerbank/+/user/update", "/powerbank/a/b/user/update", false},
+		{"$share/billing//powerbank/+/user/heart", "/powerbank/1/user/heart", true},
+		{"ops/#", "ops/a/b", true},
+		{"ops/+", "ops", false},
+	}
+	for _, tc := range cases {
+		if got := topicMatches(tc.filter, tc.topic); got != tc.want {
+			t.Errorf("topicMatches(%q, %q) = %v, want %v", tc.filter, tc.topic, got, tc.want)
+		}
+	}
+}
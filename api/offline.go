@@ -0,0 +1,104 @@
+package powerbankSdk
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// ErrDeviceOffline is returned (wrapped) by Publish for a popup over MQTT 3.1.1 to a
+// cabinet that has been offline for longer than the popup's TTL, when
+// ServerInput.OfflineAfter enables the guard: a broker that queued the command could
+// still deliver it once the cabinet reconnects, hours later.
+var ErrDeviceOffline = errors.New("device offline longer than the popup ttl")
+
+// lastSeenRetention bounds how long the offline guard remembers a silent cabinet, so
+// device IDs from stray topics do not pile up. A variable so tests can shorten it.
+var lastSeenRetention = 24 * time.Hour
+
+// lastSeen records when each cabinet last sent a frame, for the offline guard.
+type lastSeen struct {
+	mu     sync.Mutex
+	up     time.Time // our broker connection last came up; silence before is ours
+	at     map[string]time.Time
+	pruned time.Time
+}
+
+func newLastSeen() *lastSeen {
+	now := time.Now()
+	return &lastSeen{up: now, at: make(map[string]time.Time), pruned: now}
+}
+
+// connected restarts every cabinet's silence at at, when our broker connection comes
+// up: frames missed while it was down say nothing about the cabinets.
+func (l *lastSeen) connected(at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.up = at
+}
+
+// heard records a frame from deviceID received at at, forgetting, about once an hour,
+// the cabinets silent for longer than lastSeenRetention.
+func (l *lastSeen) heard(deviceID string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.at[deviceID] = at
+	if retention := lastSeenRetention; at.Sub(l.pruned) > retention/24 {
+		for id, seen := range l.at {
+			if at.Sub(seen) > retention {
+				delete(l.at, id)
+			}
+		}
+		l.pruned = at
+	}
+}
+
+// since returns how long deviceID has been silent: since its last frame, or since our
+// connection came up when that is later. It reports false for a cabinet never heard
+// from, or forgotten.
+func (l *lastSeen) since(deviceID string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at, ok := l.at[deviceID]
+	if !ok {
+		return 0, false
+	}
+	if at.Before(l.up) {
+		at = l.up
+	}
+	return time.Since(at), true
+}
+
+// isDispense reports whether typ ejects a bank.
+func isDispense(typ constants.PUBLISH_TYPE) bool {
+	return typ == constants.PUBLISH_TYPE_POPUP || typ == constants.PUBLISH_TYPE_POPUP_BY_HOLE
+}
+
+// popupTTL parses the ttl of a popup whose TTL PublishContext has defaulted. A ttl that
+// is not a number of seconds is still sent to the cabinet as given; the broker-side
+// bounds fall back to defaultPopupTTLSeconds for it.
+func popupTTL(input powerbankModels.PublishInput) time.Duration {
+	n, err := strconv.ParseUint(input.TTL, 10, 32)
+	if err != nil {
+		n = defaultPopupTTLSeconds
+	}
+	return time.Duration(n) * time.Second
+}
+
+// checkOnline refuses a popup to deviceID once it has been offline — silent for more
+// than offlineAfter — for longer than ttl. A cabinet never heard from is let through:
+// its state is unknown, not offline.
+func (s *apiService) checkOnline(deviceID string, ttl time.Duration) error {
+	if s.offlineAfter <= 0 {
+		return nil
+	}
+	if silent, ok := s.seen.since(deviceID); ok && silent > s.offlineAfter+ttl {
+		return fmt.Errorf("%s silent for %v: %w", deviceID, silent.Round(time.Second), ErrDeviceOffline)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package powerbankSdk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankMqttTest "github.com/techpartners-asia/powerbank/mqtttest"
+)
+
+// TestServerRefusesPopupToOfflineCabinet lets the cabinet go silent for longer than
+// OfflineAfter plus the popup's ttl and checks the MQTT 3.1.1 service refuses to queue a
+// popup for it until it is heard from again.
+func TestServerRefusesPopupToOfflineCabinet(t *testing.T) {
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+	beats := make(chan struct{}, 1)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{
+		OfflineAfter:      50 * time.Millisecond,
+		CallbackHeartbeat: func(string, *powerbankModels.PowerBankHealthCheckResponse, time.Time) { beats <- struct{}{} },
+	})
+	popup := func(deviceID string) error {
+		return svc.Publish(powerbankModels.PublishInput{
+			ClientID:    deviceID,
+			PublishType: constants.PUBLISH_TYPE_POPUP_BY_HOLE,
+			Data:        "1",
+			TTL:         "1",
+		})
+	}
+
+	if err := popup(testDeviceID); err != nil {
+		t.Fatalf("popup to a live cabinet: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if err := popup(testDeviceID); !errors.Is(err, ErrDeviceOffline) {
+		t.Fatalf("popup after 1.1s of silence: got %v, want ErrDeviceOffline", err)
+	}
+	// A cabinet never heard from is unknown, not offline.
+	if err := popup("864601068400000"); err != nil {
+		t.Errorf("popup to an unseen cabinet: %v", err)
+	}
+	if err := svc.Publish(powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_CHECK}); err != nil {
+		t.Errorf("check to a silent cabinet: %v", err)
+	}
+
+	if err := sim.Heartbeat(); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	select {
+	case <-beats:
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat never delivered")
+	}
+	if err := popup(testDeviceID); err != nil {
+		t.Errorf("popup after a heartbeat: %v", err)
+	}
+}
+
+// TestServerOfflineGuardAfterReconnect drops the service's broker connection after the
+// cabinet has gone silent: once reconnected, the silence before counts as ours, not the
+// cabinet's, and popups go through again.
+func TestServerOfflineGuardAfterReconnect(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker) // no heartbeat: silent after the check round-trip
+	connected := make(chan struct{}, 4)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{
+		OfflineAfter: 50 * time.Millisecond,
+		OnConnected:  func() { connected <- struct{}{} },
+	})
+	<-connected
+	popup := powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_POPUP_BY_HOLE, Data: "1", TTL: "1"}
+
+	time.Sleep(1100 * time.Millisecond)
+	if err := svc.Publish(popup); !errors.Is(err, ErrDeviceOffline) {
+		t.Fatalf("popup after 1.1s of silence: got %v, want ErrDeviceOffline", err)
+	}
+
+	broker.DisconnectClients()
+	select {
+	case <-connected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("never reconnected")
+	}
+	if err := svc.Publish(popup); err != nil {
+		t.Errorf("popup right after reconnecting: %v", err)
+	}
+}
+
+func TestServerOfflineGuardIsOptIn(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{})
+	s := svc.(*apiService)
+	s.seen.heard(testDeviceID, time.Now().Add(-time.Hour))
+	s.seen.connected(time.Now().Add(-time.Hour))
+	if err := svc.Publish(powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_POPUP_BY_HOLE, Data: "1"}); err != nil {
+		t.Errorf("popup to an hour-silent cabinet without OfflineAfter: %v", err)
+	}
+}
+
+func TestServerMQTT5PopupExpiry(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker)
+	sent := make(chan powerbankMqttTest.Message, 16)
+	if err := broker.Subscribe("/powerbank/+/user/get", func(m powerbankMqttTest.Message) { sent <- m }); err != nil {
+		t.Fatalf("broker subscribe: %v", err)
+	}
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{ProtocolVersion: 5})
+
+	cases := []struct {
+		input powerbankModels.PublishInput
+		want  uint32
+	}{
+		{powerbankModels.PublishInput{PublishType: constants.PUBLISH_TYPE_POPUP, Data: "85021618"}, defaultPopupTTLSeconds},
+		{powerbankModels.PublishInput{PublishType: constants.PUBLISH_TYPE_POPUP_BY_HOLE, Data: "2", TTL: "5"}, 5},
+		{powerbankModels.PublishInput{PublishType: constants.PUBLISH_TYPE_CHECK}, 0},
+	}
+	for _, tc := range cases {
+		tc.input.ClientID = testDeviceID
+		if err := svc.Publish(tc.input); err != nil {
+			t.Fatalf("%s: %v", tc.input.PublishType, err)
+		}
+		cmd := fmt.Sprintf(`"cmd":"%s"`, tc.input.PublishType)
+		for seen := false; !seen; {
+			select {
+			case m := <-sent:
+				// Skip whatever else is on the topic, e.g. the readiness checks.
+				if seen = strings.Contains(string(m.Payload), cmd); seen && m.MessageExpiry != tc.want {
+					t.Errorf("%s: message expiry %d, want %d", tc.input.PublishType, m.MessageExpiry, tc.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s never reached the broker", tc.input.PublishType)
+			}
+		}
+	}
+}
+
+// TestServerPassesUnparsedPopupTTL sends a ttl that is not a number of seconds through
+// as given, bounding it at the broker by the default ttl instead.
+func TestServerPassesUnparsedPopupTTL(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker)
+	sent := make(chan powerbankMqttTest.Message, 16)
+	if err := broker.Subscribe("/powerbank/+/user/get", func(m powerbankMqttTest.Message) { sent <- m }); err != nil {
+		t.Fatalf("broker subscribe: %v", err)
+	}
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{ProtocolVersion: 5})
+
+	if err := svc.Publish(powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_POPUP, Data: "85021618", TTL: "soon"}); err != nil {
+		t.Fatalf("ttl \"soon\": %v", err)
+	}
+	for {
+		select {
+		case m := <-sent:
+			if !strings.Contains(string(m.Payload), `"ttl":"soon"`) {
+				continue // e.g. the readiness check
+			}
+			if m.MessageExpiry != defaultPopupTTLSeconds {
+				t.Errorf("message expiry %d, want %d", m.MessageExpiry, defaultPopupTTLSeconds)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("popup with ttl \"soon\" never reached the broker")
+		}
+	}
+}
+
+func TestLastSeenForgetsSilentCabinets(t *testing.T) {
+	defer func(d time.Duration) { lastSeenRetention = d }(lastSeenRetention)
+	lastSeenRetention = time.Hour
+
+	l := newLastSeen()
+	t0 := time.Now()
+	l.heard("junk", t0)
+	l.heard(testDeviceID, t0.Add(30*time.Minute))
+	if len(l.at) != 2 {
+		t.Fatalf("remembered %v", l.at)
+	}
+	l.heard(testDeviceID, t0.Add(2*time.Hour))
+	if _, ok := l.at["junk"]; ok || len(l.at) != 1 {
+		t.Errorf("after the retention: remembered %v", l.at)
+	}
+}
+
+func TestLastSeenRestartsOnConnect(t *testing.T) {
+	l := newLastSeen()
+	l.connected(time.Now().Add(-2 * time.Hour))
+	if _, ok := l.since(testDeviceID); ok {
+		t.Error("cabinet never heard from: known")
+	}
+	l.heard(testDeviceID, time.Now().Add(-time.Hour))
+	if silent, ok := l.since(testDeviceID); !ok || silent < time.Hour {
+		t.Errorf("silent %v, %v, want an hour", silent, ok)
+	}
+	l.connected(time.Now())
+	if silent, _ := l.since(testDeviceID); silent > time.Minute {
+		t.Errorf("silent %v after our reconnect, want ~0", silent)
+	}
+}
@@ -0,0 +1,118 @@
+package powerbankSdk
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// pendingRequest is one PublishAndWait caller parked until a reply with the expected
+// response tag arrives from its device and satisfies match.
+type pendingRequest struct {
+	match func(res interface{}) bool
+	ch    chan interface{} // buffered 1 so resolve never blocks paho's receive goroutine
+}
+
+// pendingRequests correlates decoded /user/update frames with PublishAndWait callers.
+// Keyed by device ID + response tag (which maps 1:1 to the response cmd byte).
+type pendingRequests struct {
+	mu   sync.Mutex
+	reqs map[string][]*pendingRequest
+}
+
+func newPendingRequests() *pendingRequests {
+	return &pendingRequests{reqs: make(map[string][]*pendingRequest)}
+}
+
+func pendingKey(deviceID string, typ constants.PUBLISH_TYPE) string {
+	return deviceID + "|" + string(typ)
+}
+
+func (p *pendingRequests) add(deviceID string, typ constants.PUBLISH_TYPE, match func(res interface{}) bool) *pendingRequest {
+	req := &pendingRequest{match: match, ch: make(chan interface{}, 1)}
+	key := pendingKey(deviceID, typ)
+
+	p.mu.Lock()
+	p.reqs[key] = append(p.reqs[key], req)
+	p.mu.Unlock()
+	return req
+}
+
+func (p *pendingRequests) remove(deviceID string, typ constants.PUBLISH_TYPE, req *pendingRequest) {
+	key := pendingKey(deviceID, typ)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reqs := p.reqs[key]
+	for i, r := range reqs {
+		if r == req {
+			reqs = append(reqs[:i], reqs[i+1:]...)
+			break
+		}
+	}
+	if len(reqs) == 0 {
+		delete(p.reqs, key)
+	} else {
+		p.reqs[key] = reqs
+	}
+}
+
+// resolve hands res to every pending request it satisfies and drops them from the
+// table. Every matching waiter gets the frame: two callers awaiting the same check
+// snapshot (or a retried popup of the same SN) are all answered by one reply.
+func (p *pendingRequests) resolve(deviceID string, typ constants.PUBLISH_TYPE, res interface{}) {
+	key := pendingKey(deviceID, typ)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reqs := p.reqs[key]
+	if len(reqs) == 0 {
+		return
+	}
+	kept := reqs[:0]
+	for _, r := range reqs {
+		if r.match(res) {
+			r.ch <- res
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if len(kept) == 0 {
+		delete(p.reqs, key)
+	} else {
+		p.reqs[key] = kept
+	}
+}
+
+// awaitedResponse maps a publish to the response tag the cabinet answers it with and
+// a predicate that tells this request's reply apart from others on the same device.
+func awaitedResponse(input powerbankModels.PublishInput) (constants.PUBLISH_TYPE, func(res interface{}) bool, error) {
+	switch input.PublishType {
+	case constants.PUBLISH_TYPE_CHECK:
+		// 0x10 carries no request identity; any snapshot after the publish answers it.
+		return constants.PUBLISH_TYPE_CHECK, func(res interface{}) bool {
+			_, ok := res.(*powerbankModels.PowerBankCheckResponse)
+			return ok
+		}, nil
+	case constants.PUBLISH_TYPE_POPUP:
+		sn := input.Data
+		return constants.PUBLISH_TYPE_POPUP, func(res interface{}) bool {
+			r, ok := res.(*powerbankModels.PowerBankPopupResponse)
+			return ok && r.PowerbankSN == sn
+		}, nil
+	case constants.PUBLISH_TYPE_POPUP_BY_HOLE:
+		hole, err := strconv.Atoi(input.Data)
+		if err != nil {
+			return "", nil, fmt.Errorf("popup hole %q is not a number: %w", input.Data, err)
+		}
+		return constants.PUBLISH_TYPE_POPUP_BY_HOLE, func(res interface{}) bool {
+			r, ok := res.(*powerbankModels.PowerBankPopupByHoleResponse)
+			return ok && r.HoleIndex == hole
+		}, nil
+	default:
+		return "", nil, fmt.Errorf("publish type %v has no awaitable response", input.PublishType)
+	}
+}
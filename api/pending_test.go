@@ -0,0 +1,63 @@
+package powerbankSdk
+
+import (
+	"testing"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// TestPendingRequestsMatchBySNAndHole checks that replies are routed only to the
+// waiter whose device, response cmd and SN/hole they answer.
+func TestPendingRequestsMatchBySNAndHole(t *testing.T) {
+	p := newPendingRequests()
+
+	snTyp, snMatch, err := awaitedResponse(powerbankModels.PublishInput{ClientID: "dev", PublishType: constants.PUBLISH_TYPE_POPUP, Data: "85021618"})
+	if err != nil {
+		t.Fatalf("popup_sn: %v", err)
+	}
+	holeTyp, holeMatch, err := awaitedResponse(powerbankModels.PublishInput{ClientID: "dev", PublishType: constants.PUBLISH_TYPE_POPUP_BY_HOLE, Data: "5"})
+	if err != nil {
+		t.Fatalf("popup: %v", err)
+	}
+	snReq := p.add("dev", snTyp, snMatch)
+	holeReq := p.add("dev", holeTyp, holeMatch)
+
+	// Wrong SN, wrong device, wrong hole: nobody is woken.
+	p.resolve("dev", constants.PUBLISH_TYPE_POPUP, &powerbankModels.PowerBankPopupResponse{PowerbankSN: "1"})
+	p.resolve("other", constants.PUBLISH_TYPE_POPUP, &powerbankModels.PowerBankPopupResponse{PowerbankSN: "85021618"})
+	p.resolve("dev", constants.PUBLISH_TYPE_POPUP_BY_HOLE, &powerbankModels.PowerBankPopupByHoleResponse{HoleIndex: 6})
+	select {
+	case res := <-snReq.ch:
+		t.Fatalf("popup_sn waiter woken by %+v", res)
+	case res := <-holeReq.ch:
+		t.Fatalf("popup waiter woken by %+v", res)
+	default:
+	}
+
+	want := &powerbankModels.PowerBankPopupResponse{PowerbankSN: "85021618", State: 0x01}
+	p.resolve("dev", constants.PUBLISH_TYPE_POPUP, want)
+	if got := <-snReq.ch; got != want {
+		t.Errorf("popup_sn waiter: got %+v want %+v", got, want)
+	}
+
+	p.resolve("dev", constants.PUBLISH_TYPE_POPUP_BY_HOLE, &powerbankModels.PowerBankPopupByHoleResponse{HoleIndex: 5})
+	if got := (<-holeReq.ch).(*powerbankModels.PowerBankPopupByHoleResponse); got.HoleIndex != 5 {
+		t.Errorf("popup waiter: got hole %d want 5", got.HoleIndex)
+	}
+
+	if len(p.reqs) != 0 {
+		t.Errorf("resolved requests left in table: %v", p.reqs)
+	}
+}
+
+func TestAwaitedResponseRejectsUnawaitable(t *testing.T) {
+	for _, typ := range []constants.PUBLISH_TYPE{constants.PUBLISH_TYPE_LOAD_AD, constants.PUBLISH_TYPE_REBOOT} {
+		if _, _, err := awaitedResponse(powerbankModels.PublishInput{ClientID: "dev", PublishType: typ}); err == nil {
+			t.Errorf("%v: expected error", typ)
+		}
+	}
+	if _, _, err := awaitedResponse(powerbankModels.PublishInput{ClientID: "dev", PublishType: constants.PUBLISH_TYPE_POPUP_BY_HOLE, Data: "x"}); err == nil {
+		t.Errorf("non-numeric hole: expected error")
+	}
+}
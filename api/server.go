@@ -1,14 +1,14 @@
 package powerbankSdk
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
-	"strings"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/techpartners-asia/powerbank/constants"
 	powerbankModels "github.com/techpartners-asia/powerbank/models"
 	powerbankUtils "github.com/techpartners-asia/powerbank/utils"
@@ -17,162 +17,284 @@ import (
 // defaultPopupTTLSeconds is the ttl applied to a popup the caller did not stamp, so we
 // always emit the documented timestamp+ttl form. NOTE: cabinet firmware was verified on
 // real hardware NOT to honor timestamp+ttl (stale commands, even 2h old, still eject),
-// so this does not time-bound the command — it is the spec form only.
+// so the cabinet does not time-bound the command. The ttl bounds it at the broker
+// instead: as the message expiry on MQTT 5, and through the offline guard on 3.1.1.
 const defaultPopupTTLSeconds = 30
 
+// defaultAwaitTimeout bounds PublishAndWait when the caller's ctx has no deadline, so a
+// reply the cabinet never sends cannot park the caller forever.
+const defaultAwaitTimeout = 30 * time.Second
+
+// subscribeTimeout bounds the wait for each SUBACK.
+const subscribeTimeout = 10 * time.Second
+
 // ApiService is the MQTT publish surface for the Volinks Powerbank Protocol V1.
 // Protocol reference: https://docs.volinks.com/powerbank-protocol-v1/en/
 type ApiService interface {
 	Publish(input powerbankModels.PublishInput) error
+	// PublishContext is Publish bounded by ctx: it refuses to publish once ctx is done
+	// and stops waiting for the broker when ctx is cancelled mid-publish.
+	PublishContext(ctx context.Context, input powerbankModels.PublishInput) error
+	// PublishAndWait publishes input and blocks until the cabinet's reply to it arrives:
+	// the 0x10 snapshot for check, the 0x31 frame echoing the SN for popup_sn, or the
+	// 0x21 frame for the same hole for popup. It returns the typed response
+	// (*PowerBankCheckResponse, *PowerBankPopupResponse or *PowerBankPopupByHoleResponse),
+	// or an error wrapping ctx.Err() once ctx is done. Without a ctx deadline the wait is
+	// bounded by defaultAwaitTimeout. The reply is still delivered to the Handler.
+	// It returns ErrSharedSubscription on a service with a SharedGroup.
+	PublishAndWait(ctx context.Context, input powerbankModels.PublishInput) (interface{}, error)
+	// Dispense ejects the bank sn from deviceID without risking a second bank: it
+	// publishes popup_sn and awaits the 0x31. When that reply fails or never comes it
+	// publishes check, and re-pops with a fresh timestamp only while the check shows the
+	// bank still in its slot. A bank gone from its slot after an unanswered popup counts
+	// as ejected. The result holds every command sent. The error is non-nil only when
+	// not even the first popup could be published. It returns ErrSharedSubscription on a
+	// service with a SharedGroup.
+	Dispense(ctx context.Context, deviceID, sn string) (*powerbankModels.DispenseResult, error)
+	// Status reports the broker connection state, for readiness probes: whether the
+	// client is connected and subscribed, since when, and how often it reconnected.
+	Status() powerbankModels.ServiceStatus
 	// Disconnect cleanly closes the underlying MQTT connection. Call this before
 	// dropping an ApiService (e.g. when rebuilding it) so the old client and its
 	// background goroutines do not leak.
 	Disconnect()
 }
 
+// ErrNoDeviceID is reported to Handler.OnParseError for a frame whose topic carries
+// no device ID, so it cannot be attributed to a cabinet.
+var ErrNoDeviceID = errors.New("device ID missing from topic")
+
 type apiService struct {
-	client mqtt.Client
-	debug  bool
+	client  transport
+	debug   bool
+	v5      bool
+	pending *pendingRequests
+	state   connState
+	topics  *topicLayouts
+	shared  bool
+	seen    *lastSeen
+	parser  powerbankUtils.Parser
+	keys    powerbankModels.IdempotencyStore
+	// offlineAfter is how long a silent cabinet counts as online; zero disables the
+	// offline guard.
+	offlineAfter time.Duration
 }
 
 func NewServer(input powerbankModels.ServerInput) (ApiService, error) {
 	powerbankUtils.Debug = input.Debug
-	if input.Debug {
-		mqtt.DEBUG = log.New(os.Stdout, "[mqtt] ", log.LstdFlags)
-		mqtt.ERROR = log.New(os.Stderr, "[mqtt-err] ", log.LstdFlags)
-	}
-
-	// Subscription handlers are defined once so the OnConnect handler can
-	// (re)attach them on every connect AND reconnect.
-	onUpdate := func(_ mqtt.Client, msg mqtt.Message) {
-		// Parsing below is panic-free by design (every parser bounds-checks its input).
-		// This recover is the isolation boundary around the host's CallbackSubscribe —
-		// code the SDK does not control, run here in paho's receive goroutine, where an
-		// unrecovered panic would terminate the whole process. It is logged loudly
-		// (not swallowed) so a host-callback bug surfaces instead of hiding.
-		defer func() {
-			if r := recover(); r != nil {
-				fmt.Fprintf(os.Stderr, "[powerbank-sdk] recovered panic in update handler: %v\n", r)
-			}
-		}()
-		typ, res, err := powerbankUtils.ParseResponse(msg.Payload())
-		if err != nil {
-			if input.Debug {
-				fmt.Println(err)
-			}
-			return
+
+	handler, err := newHandler(input)
+	if err != nil {
+		return nil, err
+	}
+	topics, err := newTopicLayouts(input.Topics)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSharedGroup(input.SharedGroup); err != nil {
+		return nil, err
+	}
+	if input.ProtocolVersion != 0 && input.ProtocolVersion != 4 && input.ProtocolVersion != 5 {
+		return nil, fmt.Errorf("unsupported MQTT protocol version %d (want 4 or 5)", input.ProtocolVersion)
+	}
+	s := &apiService{
+		debug:   input.Debug,
+		v5:      input.ProtocolVersion == 5,
+		pending: newPendingRequests(),
+		topics:  topics,
+		shared:  input.SharedGroup != "",
+		seen:    newLastSeen(),
+		parser:  powerbankUtils.Parser{LenientChecksum: input.LenientChecksum},
+		keys:    input.IdempotencyStore,
+	}
+	if s.keys == nil {
+		s.keys = NewMemoryIdempotencyStore(defaultIdempotencyKeys)
+	}
+	if input.SharedGroup == "" && input.OfflineAfter > 0 {
+		s.offlineAfter = input.OfflineAfter
+	}
+
+	// parseFailed reports an undecodable frame to the host. The payload is copied so
+	// the host may keep it, e.g. as a dead-letter record.
+	parseFailed := func(deviceID, topic string, payload []byte, err error) {
+		if input.Debug {
+			fmt.Printf("[powerbank-sdk] undecodable frame on %s: %v\n", topic, err)
 		}
+		handler.OnParseError(deviceID, topic, append([]byte(nil), payload...), err)
+	}
 
-		parts := strings.Split(msg.Topic(), "/")
-		if len(parts) < 3 || parts[2] == "" {
-			if input.Debug {
-				fmt.Println("DeviceID missing from subscribe topic")
+	// Subscription handlers are built once per topic layout so the OnConnect handler
+	// can (re)attach them on every connect AND reconnect. The device ID is read from
+	// the layout's {device} level.
+	onUpdate := func(layout int) func(topic string, payload []byte) {
+		template := topics.layouts[layout].Update
+		return func(topic string, payload []byte) {
+			// Parsing below is panic-free by design (every parser bounds-checks its input).
+			// This recover is the isolation boundary around the host's Handler —
+			// code the SDK does not control, run here in paho's receive goroutine, where an
+			// unrecovered panic would terminate the whole process. It is logged loudly
+			// (not swallowed) so a host-callback bug surfaces instead of hiding.
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Fprintf(os.Stderr, "[powerbank-sdk] recovered panic in update handler: %v\n", r)
+				}
+			}()
+			deviceID, ok := powerbankUtils.TopicDeviceID(template, topic)
+			if !ok {
+				parseFailed("", topic, payload, ErrNoDeviceID)
+				return
 			}
-			return
-		}
+			topics.heardFrom(deviceID, layout)
+			s.seen.heard(deviceID, time.Now())
+
+			typ, res, err := s.parser.ParseResponse(payload)
+			if err != nil {
+				parseFailed(deviceID, topic, payload, err)
+				return
+			}
+
+			// Wake PublishAndWait callers first so a slow or panicking host callback
+			// cannot delay or swallow their reply.
+			s.pending.resolve(deviceID, typ, res)
 
-		input.CallbackSubscribe(typ, parts[2], res)
+			dispatch(handler, deviceID, res)
+		}
 	}
 
-	onHeart := func(_ mqtt.Client, msg mqtt.Message) {
-		defer func() {
-			if r := recover(); r != nil {
-				fmt.Fprintf(os.Stderr, "[powerbank-sdk] recovered panic in heart handler: %v\n", r)
+	onHeart := func(layout int) func(topic string, payload []byte) {
+		template := topics.layouts[layout].Heart
+		return func(topic string, payload []byte) {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Fprintf(os.Stderr, "[powerbank-sdk] recovered panic in heart handler: %v\n", r)
+				}
+			}()
+			// Stamp before parsing so the receive time reflects arrival, not callback order.
+			receivedAt := time.Now()
+			deviceID, ok := powerbankUtils.TopicDeviceID(template, topic)
+			if !ok {
+				parseFailed("", topic, payload, ErrNoDeviceID)
+				return
+			}
+			topics.heardFrom(deviceID, layout)
+			s.seen.heard(deviceID, receivedAt)
+
+			res, err := s.parser.ParseHealthCheckResponse(payload)
+			if err != nil {
+				parseFailed(deviceID, topic, payload, err)
+				return
 			}
-		}()
-		parts := strings.Split(msg.Topic(), "/")
-		if len(parts) < 3 || parts[2] == "" {
-			return
-		}
-		deviceID := parts[2]
 
-		res, err := powerbankUtils.ParseHealthCheckResponse(msg.Payload())
-		if err != nil {
 			if input.Debug {
-				fmt.Println(err)
+				fmt.Printf("[heart] device=%s signal=%v backup=%v\n", deviceID, res.GetSignalStrength(), res.GetBackupPowerStatus())
 			}
-			return
-		}
 
-		if input.Debug {
-			fmt.Printf("[heart] device=%s signal=%v backup=%v\n", deviceID, res.GetSignalStrength(), res.GetBackupPowerStatus())
+			handler.OnHeartbeat(deviceID, res, receivedAt)
 		}
 	}
 
-	opts := mqtt.NewClientOptions().AddBroker(fmt.Sprintf("tcp://%s:%s", input.Host, input.Port))
-	opts.SetUsername(input.Username)
-	opts.SetPassword(input.Password)
-	opts.SetKeepAlive(30 * time.Second)
-	opts.SetPingTimeout(10 * time.Second)
-	// Auto-reconnect dropped sessions and cap the backoff — paho's 10m default is
-	// far too slow to recover from a blip.
-	opts.SetAutoReconnect(true)
-	opts.SetMaxReconnectInterval(30 * time.Second)
-
-	// Subscribe INSIDE OnConnect so subscriptions are (re)established on every
-	// connect AND auto-reconnect. With CleanSession=true (paho default) the broker
-	// discards subscriptions on disconnect; subscribing only once after Connect()
-	// leaves an auto-reconnected client "connected" but receiving nothing — the
-	// silent half-dead state that stops popup/check/return callbacks. This is the fix.
-	opts.SetOnConnectHandler(func(c mqtt.Client) {
-		c.Subscribe(string(constants.TOPIC_SUBSCRIBE), 0, onUpdate)
-		c.Subscribe("/powerbank/+/user/heart", 0, onHeart)
-	})
-	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
-		if input.Debug {
-			fmt.Printf("[mqtt] connection lost: %v\n", err)
+	var subs []subscription
+	for i, l := range topics.layouts {
+		subs = append(subs,
+			subscription{sharedFilter(input.SharedGroup, powerbankUtils.TopicFilter(l.Update)), onUpdate(i)},
+			subscription{sharedFilter(input.SharedGroup, powerbankUtils.TopicFilter(l.Heart)), onHeart(i)},
+		)
+	}
+
+	broker, useTLS, err := brokerURL(input)
+	if err != nil {
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if useTLS {
+		if tlsConfig, err = newTLSConfig(input.TLS, input.Host); err != nil {
+			return nil, fmt.Errorf("mqtt tls: %w", err)
 		}
-	})
+	}
 
-	if input.Debug {
-		opts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
-			fmt.Printf("TOPIC: %s\n", msg.Topic())
-			fmt.Printf("MSG: %s\n", msg.Payload())
-		})
+	events := connEvents{
+		up: func(t transport) {
+			s.seen.connected(time.Now())
+			gen := s.state.connected()
+			// A rejected or unanswered SUBSCRIBE is retried rather than ignored: an
+			// unsubscribed client is connected but deaf.
+			ok := s.subscribeAll(t, gen, subs, func(err error) {
+				if input.OnSubscribeError != nil {
+					callHook("OnSubscribeError", func() { input.OnSubscribeError(err) })
+				}
+			})
+			if ok && input.OnConnected != nil {
+				callHook("OnConnected", input.OnConnected)
+			}
+		},
+		down: func(err error) {
+			s.state.lost(err)
+			if input.OnConnectionLost != nil {
+				callHook("OnConnectionLost", func() { input.OnConnectionLost(err) })
+			}
+		},
+		reconnecting: func() {
+			if input.OnReconnecting != nil {
+				callHook("OnReconnecting", input.OnReconnecting)
+			}
+		},
 	}
 
-	c := mqtt.NewClient(opts)
-	// Block on the initial connect so callers still get an error if the broker is
-	// unreachable at startup; OnConnect handles (re)subscription from here on.
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		return nil, fmt.Errorf("mqtt connect: %w", token.Error())
+	if s.v5 {
+		s.client, err = newV5Transport(input, broker, tlsConfig, events)
+	} else {
+		s.client, err = newV3Transport(input, broker, tlsConfig, events)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return &apiService{client: c, debug: input.Debug}, nil
+	return s, nil
 }
 
 func (s *apiService) Disconnect() {
 	if s.client != nil {
-		// Unconditional (not only when IsConnected): a client mid-auto-reconnect
-		// reports IsConnected()==false but still runs a background reconnect
-		// goroutine; Disconnect tears it down. Guarding on IsConnected would leak it.
-		s.client.Disconnect(250)
+		s.client.disconnect()
 	}
+	s.state.closed()
+}
+
+func (s *apiService) Status() powerbankModels.ServiceStatus {
+	return s.state.snapshot()
 }
 
 func (s *apiService) Publish(input powerbankModels.PublishInput) error {
+	return s.PublishContext(context.Background(), input)
+}
+
+func (s *apiService) PublishContext(ctx context.Context, input powerbankModels.PublishInput) error {
+	// An abandoned request must not reach the cabinet: a popup published after the
+	// end-user gave up still ejects a bank.
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("mqtt publish: %w", err)
+	}
+
 	var payload string
-	var topic string
 
 	// Default the popup timestamp+ttl so we always send the documented enhanced form
 	// (harmless even though this firmware ignores it — see the publish call for why
 	// dispenses stay QoS 0).
-	if input.PublishType == constants.PUBLISH_TYPE_POPUP || input.PublishType == constants.PUBLISH_TYPE_POPUP_BY_HOLE {
+	var ttl time.Duration
+	if isDispense(input.PublishType) {
 		if input.Timestamp == "" {
 			input.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
 		}
 		if input.TTL == "" {
 			input.TTL = strconv.Itoa(defaultPopupTTLSeconds)
 		}
+		ttl = popupTTL(input)
 	}
 
 	switch input.PublishType {
 	case constants.PUBLISH_TYPE_CHECK:
 		payload = fmt.Sprintf("{\"cmd\":\"%v\"}", constants.PUBLISH_TYPE_CHECK)
-		topic = fmt.Sprintf(string(constants.TOPIC_PUBLISH), input.ClientID)
 	case constants.PUBLISH_TYPE_REBOOT:
 		payload = fmt.Sprintf("{\"cmd\":\"%v\"}", constants.PUBLISH_TYPE_REBOOT)
-		topic = fmt.Sprintf(string(constants.TOPIC_PUBLISH), input.ClientID)
 	case constants.PUBLISH_TYPE_POPUP_BY_HOLE:
 		io := input.IO
 		if io == "" {
@@ -184,23 +306,26 @@ func (s *apiService) Publish(input powerbankModels.PublishInput) error {
 			payload = fmt.Sprintf("{\"cmd\":\"%v\",\"data\":\"%v\",\"io\":\"%v\"}",
 				constants.PUBLISH_TYPE_POPUP_BY_HOLE, input.Data, io)
 		}
-		topic = fmt.Sprintf(string(constants.TOPIC_PUBLISH), input.ClientID)
 	case constants.PUBLISH_TYPE_POPUP:
 		if input.Timestamp != "" && input.TTL != "" {
 			payload = fmt.Sprintf("{\"cmd\":\"%v\",\"data\":\"%v\",\"timestamp\":\"%v\",\"ttl\":\"%v\"}",
 				constants.PUBLISH_TYPE_POPUP, input.Data, input.Timestamp, input.TTL)		} else {
 			payload = fmt.Sprintf("{\"cmd\":\"%v\",\"data\":\"%v\"}", constants.PUBLISH_TYPE_POPUP, input.Data)
 		}
-		topic = fmt.Sprintf(string(constants.TOPIC_PUBLISH), input.ClientID)
 	case constants.PUBLISH_TYPE_UPLOAD:
 		payload = fmt.Sprintf("{\"cmd\":\"%v\"}", constants.PUBLISH_TYPE_UPLOAD)
-		topic = fmt.Sprintf(string(constants.TOPIC_PUBLISH), input.ClientID)
 	case constants.PUBLISH_TYPE_LOAD_AD:
 		payload = "{\"cmd\":\"load_ad\"}"
-		topic = fmt.Sprintf(string(constants.TOPIC_PUBLISH), input.ClientID)
 	default:
 		return fmt.Errorf("invalid publish type: %v", input.PublishType)
 	}
+	if !s.v5 && hasV5Properties(input) {
+		return fmt.Errorf("mqtt publish: %w", ErrRequiresMQTT5)
+	}
+	topic, err := s.topics.publishTopic(input.ClientID, input.Layout)
+	if err != nil {
+		return fmt.Errorf("mqtt publish: %w", err)
+	}
 
 	// QoS 0. A dispense is a NON-IDEMPOTENT physical action; MQTT QoS 1 is at-least-once,
 	// so a lost PUBACK makes the broker redeliver (DUP=1) and this firmware will eject a
@@ -209,9 +334,41 @@ func (s *apiService) Publish(input powerbankModels.PublishInput) error {
 	// late eject. Reliability for a dropped dispense is handled application-side (re-pop
 	// with a fresh timestamp after a positive non-dispense check), never by broker
 	// redelivery of a non-idempotent command.
-	token := s.client.Publish(topic, 0, false, payload)
-	token.Wait()
-	if err := token.Error(); err != nil {
+	// On MQTT 5 the idempotent commands go at QoS 1 instead, so the PUBACK reason code
+	// (e.g. 0x87 Not authorized) reaches the caller as a *ReasonCodeError.
+	msg := outbound{topic: topic, payload: []byte(payload), input: input}
+	if s.v5 && idempotent(input.PublishType) {
+		msg.qos = 1
+	}
+
+	// The firmware ejects a popup however old it is, so the broker must not deliver one
+	// past its ttl, e.g. from a queue the cabinet drains when it reconnects. MQTT 5
+	// expires it there; over 3.1.1 we refuse to send it to a cabinet that has already
+	// been offline for longer than that.
+	if isDispense(input.PublishType) {
+		if s.v5 {
+			msg.expiry = uint32(ttl / time.Second)
+		} else if err := s.checkOnline(input.ClientID, ttl); err != nil {
+			return fmt.Errorf("mqtt publish: %w", err)
+		}
+	}
+	// Reserve the idempotency key last, once nothing but the publish itself can fail.
+	var abort func()
+	if input.IdempotencyKey != "" && isDispense(input.PublishType) {
+		// Another replica of the group may get the reply, leaving the key unsettled here.
+		if s.shared {
+			return fmt.Errorf("mqtt publish: idempotency key: %w", ErrSharedSubscription)
+		}
+		if abort, err = s.reserveDispense(ctx, input); err != nil {
+			return fmt.Errorf("mqtt publish: %w", err)
+		}
+	}
+	if err := s.client.publish(ctx, msg); err != nil {
+		// A publish abandoned with ctx may still go out; keep its key until the reply
+		// or the timeout.
+		if abort != nil && ctx.Err() == nil {
+			abort()
+		}
 		return fmt.Errorf("mqtt publish: %w", err)
 	}
 
@@ -221,3 +378,40 @@ func (s *apiService) Publish(input powerbankModels.PublishInput) error {
 
 	return nil
 }
+
+func (s *apiService) PublishAndWait(ctx context.Context, input powerbankModels.PublishInput) (interface{}, error) {
+	if s.shared {
+		return nil, ErrSharedSubscription
+	}
+	typ, match, err := awaitedResponse(input)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultAwaitTimeout)
+		defer cancel()
+	}
+
+	res, _, err := s.publishAndWait(ctx, input, typ, match)
+	return res, err
+}
+
+// publishAndWait publishes input and waits for the reply of type typ satisfying match.
+// published reports whether the command went out, i.e. an error is a missing reply.
+func (s *apiService) publishAndWait(ctx context.Context, input powerbankModels.PublishInput, typ constants.PUBLISH_TYPE, match func(res interface{}) bool) (res interface{}, published bool, err error) {
+	// Register before publishing: a fast cabinet can answer before Publish returns.
+	req := s.pending.add(input.ClientID, typ, match)
+	defer s.pending.remove(input.ClientID, typ, req)
+
+	if err := s.PublishContext(ctx, input); err != nil {
+		return nil, false, err
+	}
+
+	select {
+	case res := <-req.ch:
+		return res, true, nil
+	case <-ctx.Done():
+		return nil, true, fmt.Errorf("await %v reply from %s: %w", typ, input.ClientID, ctx.Err())
+	}
+}
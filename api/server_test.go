@@ -0,0 +1,226 @@
+package powerbankSdk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankMqttTest "github.com/techpartners-asia/powerbank/mqtttest"
+	powerbankSimulator "github.com/techpartners-asia/powerbank/simulator"
+)
+
+const testDeviceID = "864601068412899"
+
+// subscribeEvent is one CallbackSubscribe invocation.
+type subscribeEvent struct {
+	typ      constants.PUBLISH_TYPE
+	deviceID string
+	msg      interface{}
+}
+
+// newTestBroker starts an in-process broker that is closed with the test.
+func newTestBroker(t *testing.T) *powerbankMqttTest.Broker {
+	t.Helper()
+	broker, err := powerbankMqttTest.NewBroker()
+	if err != nil {
+		t.Fatalf("broker: %v", err)
+	}
+	t.Cleanup(broker.Close)
+	return broker
+}
+
+// newTestCabinet connects a simulated cabinet with two boards (holes 1-8) and no
+// periodic heartbeat.
+func newTestCabinet(t *testing.T, broker *powerbankMqttTest.Broker) *powerbankSimulator.Simulator {
+	t.Helper()
+	sim, err := powerbankSimulator.NewSimulator(powerbankModels.SimulatorInput{
+		Host:              broker.Host(),
+		Port:              broker.Port(),
+		DeviceID:          testDeviceID,
+		Boards:            2,
+		HeartbeatInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("simulator: %v", err)
+	}
+	t.Cleanup(sim.Close)
+	return sim
+}
+
+// newTestServer connects an ApiService to broker, filling in the connection fields of
+// input, and waits until a check round-trips through the simulated cabinet so both
+// sides' subscriptions are live.
+func newTestServer(t *testing.T, broker *powerbankMqttTest.Broker, input powerbankModels.ServerInput) ApiService {
+	t.Helper()
+	input.Host = broker.Host()
+	input.Port = broker.Port()
+	svc, err := NewServer(input)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(svc.Disconnect)
+	awaitCheck(t, svc, powerbankModels.PublishInput{ClientID: testDeviceID})
+	return svc
+}
+
+// awaitCheck retries a check on the device in input until it is answered, i.e. the
+// service's and the cabinet's subscriptions are both live.
+func awaitCheck(t *testing.T, svc ApiService, input powerbankModels.PublishInput) {
+	t.Helper()
+	input.PublishType = constants.PUBLISH_TYPE_CHECK
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		_, err := svc.PublishAndWait(ctx, input)
+		cancel()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never answered a check: %v", input.ClientID, err)
+		}
+	}
+}
+
+// awaitReady waits until the broker has acknowledged every subscription of svc, not
+// only the update topic a check proves.
+func awaitReady(t *testing.T, svc ApiService) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !svc.Status().Ready(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("never subscribed: %+v", svc.Status())
+		}
+	}
+}
+
+func TestServerPublishAndWaitPopupBySN(t *testing.T) {
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+	if err := sim.Insert(6, "85021618", 90); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	events := make(chan subscribeEvent, 16)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{
+		CallbackSubscribe: func(typ constants.PUBLISH_TYPE, deviceID string, msg interface{}) {
+			events <- subscribeEvent{typ, deviceID, msg}
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := svc.PublishAndWait(ctx, powerbankModels.PublishInput{
+		ClientID:    testDeviceID,
+		PublishType: constants.PUBLISH_TYPE_POPUP,
+		Data:        "85021618",
+	})
+	if err != nil {
+		t.Fatalf("PublishAndWait: %v", err)
+	}
+	popup, ok := res.(*powerbankModels.PowerBankPopupResponse)
+	if !ok || popup.State != powerbankModels.PopupSuccess || popup.HoleIndex != 6 {
+		t.Fatalf("popup reply: %#v", res)
+	}
+
+	// The same reply still reaches CallbackSubscribe (after the readiness checks).
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.typ != constants.PUBLISH_TYPE_POPUP {
+				continue
+			}
+			if ev.deviceID != testDeviceID || ev.msg.(*powerbankModels.PowerBankPopupResponse).PowerbankSN != "85021618" {
+				t.Errorf("callback event: %+v", ev)
+			}
+			return
+		case <-timeout:
+			t.Fatal("popup reply never reached CallbackSubscribe")
+		}
+	}
+}
+
+func TestServerPublishAndWaitTimesOutOnLostReply(t *testing.T) {
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+	if err := sim.Insert(1, "85021618", 90); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{})
+
+	sim.DropNext(constants.PUBLISH_TYPE_POPUP_BY_HOLE, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err := svc.PublishAndWait(ctx, powerbankModels.PublishInput{
+		ClientID:    testDeviceID,
+		PublishType: constants.PUBLISH_TYPE_POPUP_BY_HOLE,
+		Data:        "1",
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestServerDeliversHeartbeat(t *testing.T) {
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+
+	type heartbeat struct {
+		deviceID   string
+		msg        *powerbankModels.PowerBankHealthCheckResponse
+		receivedAt time.Time
+	}
+	beats := make(chan heartbeat, 1)
+	newTestServer(t, broker, powerbankModels.ServerInput{
+		CallbackHeartbeat: func(deviceID string, msg *powerbankModels.PowerBankHealthCheckResponse, receivedAt time.Time) {
+			beats <- heartbeat{deviceID, msg, receivedAt}
+		},
+	})
+
+	before := time.Now()
+	if err := sim.Heartbeat(); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	select {
+	case hb := <-beats:
+		if hb.deviceID != testDeviceID || hb.msg.GetCSQValue() != 27 || hb.receivedAt.Before(before) {
+			t.Errorf("heartbeat: device=%s csq=%d at=%v (sent after %v)", hb.deviceID, hb.msg.GetCSQValue(), hb.receivedAt, before)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat never delivered")
+	}
+}
+
+// TestServerPublishContextRefusesDoneContext checks that a popup whose ctx is already
+// cancelled never reaches the cabinet.
+func TestServerPublishContextRefusesDoneContext(t *testing.T) {
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+	if err := sim.Insert(1, "85021618", 90); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := svc.PublishContext(ctx, powerbankModels.PublishInput{
+		ClientID:    testDeviceID,
+		PublishType: constants.PUBLISH_TYPE_POPUP,
+		Data:        "85021618",
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	// A check issued afterwards is answered after any popup would have been handled.
+	res, err := svc.PublishAndWait(context.Background(), powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_CHECK})
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if h := res.(*powerbankModels.PowerBankCheckResponse).ControlBoards[0].Holes[0]; h.PowerbankSN != "85021618" {
+		t.Errorf("slot 1 after cancelled popup: %+v", h)
+	}
+}
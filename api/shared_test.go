@@ -0,0 +1,182 @@
+package powerbankSdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankMqttTest "github.com/techpartners-asia/powerbank/mqtttest"
+	powerbankSimulator "github.com/techpartners-asia/powerbank/simulator"
+)
+
+// countingHandler counts the returns one replica receives, by SN and by device.
+type countingHandler struct {
+	powerbankModels.NopHandler
+	mu      *sync.Mutex
+	seen    map[string]int
+	replica map[string]int
+	devices map[string]map[string]int // device -> replica -> returns
+	name    string
+}
+
+func (h *countingHandler) OnReturn(deviceID string, msg *powerbankModels.PowerBankReturnResponse) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seen[msg.PowerbankSN]++
+	h.replica[h.name]++
+	if h.devices[deviceID] == nil {
+		h.devices[deviceID] = make(map[string]int)
+	}
+	h.devices[deviceID][h.name]++
+}
+
+// sharedReplicas connects two replicas "a" and "b" in one shared group, counting their
+// returns in h's maps, and waits until both are subscribed.
+func sharedReplicas(t *testing.T, broker *powerbankMqttTest.Broker, h countingHandler) []ApiService {
+	t.Helper()
+	var replicas []ApiService
+	for _, name := range []string{"a", "b"} {
+		handler := h
+		handler.name = name
+		svc, err := NewServer(powerbankModels.ServerInput{
+			Host:        broker.Host(),
+			Port:        broker.Port(),
+			SharedGroup: "billing",
+			Handler:     &handler,
+		})
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		t.Cleanup(svc.Disconnect)
+		replicas = append(replicas, svc)
+	}
+	for _, svc := range replicas {
+		awaitReady(t, svc)
+	}
+	return replicas
+}
+
+// awaitReturns waits until the replicas have processed n returns in all, then a little
+// longer for any duplicate.
+func awaitReturns(t *testing.T, h countingHandler, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		h.mu.Lock()
+		total := h.replica["a"] + h.replica["b"]
+		h.mu.Unlock()
+		if total >= n || time.Now().After(deadline) {
+			break
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+}
+
+func newCountingHandler() countingHandler {
+	return countingHandler{
+		mu:      &sync.Mutex{},
+		seen:    make(map[string]int),
+		replica: make(map[string]int),
+		devices: make(map[string]map[string]int),
+	}
+}
+
+// TestServerSharedSubscriptionDeliversOnce runs two replicas in one shared group and
+// checks every return frame is processed by exactly one of them. Which one is up to
+// the broker's strategy; the test broker's default picks any member, so the split is
+// not asserted.
+func TestServerSharedSubscriptionDeliversOnce(t *testing.T) {
+	broker := newTestBroker(t)
+	sim := newTestCabinet(t, broker)
+	h := newCountingHandler()
+	replicas := sharedReplicas(t, broker, h)
+
+	const returns = 40
+	for i := 0; i < returns; i++ {
+		if err := sim.InjectReturn(1+i%8, fmt.Sprint(85021600+i), 90); err != nil {
+			t.Fatalf("inject return: %v", err)
+		}
+	}
+	awaitReturns(t, h, returns)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.seen) != returns {
+		t.Errorf("%d distinct returns processed, want %d", len(h.seen), returns)
+	}
+	for sn, n := range h.seen {
+		if n != 1 {
+			t.Errorf("return %s processed %d times", sn, n)
+		}
+	}
+
+	_, err := replicas[0].PublishAndWait(context.Background(), powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_CHECK})
+	if !errors.Is(err, ErrSharedSubscription) {
+		t.Errorf("PublishAndWait: got %v, want ErrSharedSubscription", err)
+	}
+}
+
+// TestServerSharedSubscriptionSticky emulates EMQX's hash_clientid strategy and checks
+// every frame of one cabinet reaches the same replica.
+func TestServerSharedSubscriptionSticky(t *testing.T) {
+	broker := newTestBroker(t)
+	broker.HashSharedSubscriptions()
+	var cabinets []*powerbankSimulator.Simulator
+	for _, id := range []string{testDeviceID, "864601068400000", "864601068400001", "864601068400002"} {
+		sim, err := powerbankSimulator.NewSimulator(powerbankModels.SimulatorInput{
+			Host:              broker.Host(),
+			Port:              broker.Port(),
+			DeviceID:          id,
+			HeartbeatInterval: -1,
+		})
+		if err != nil {
+			t.Fatalf("simulator: %v", err)
+		}
+		t.Cleanup(sim.Close)
+		cabinets = append(cabinets, sim)
+	}
+	h := newCountingHandler()
+	sharedReplicas(t, broker, h)
+
+	const perCabinet = 10
+	for i := 0; i < perCabinet; i++ {
+		for c, sim := range cabinets {
+			if err := sim.InjectReturn(1+i%4, fmt.Sprint(85020000+c*100+i), 90); err != nil {
+				t.Fatalf("inject return: %v", err)
+			}
+		}
+	}
+	awaitReturns(t, h, perCabinet*len(cabinets))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.devices) != len(cabinets) {
+		t.Fatalf("returns from %d cabinets, want %d: %v", len(h.devices), len(cabinets), h.devices)
+	}
+	for device, byReplica := range h.devices {
+		if len(byReplica) != 1 {
+			t.Errorf("%s: frames split across replicas: %v", device, byReplica)
+		}
+		for _, n := range byReplica {
+			if n != perCabinet {
+				t.Errorf("%s: %d returns processed, want %d", device, n, perCabinet)
+			}
+		}
+	}
+}
+
+func TestSharedFilter(t *testing.T) {
+	if got := sharedFilter("billing", "/powerbank/+/user/update"); got != "$share/billing//powerbank/+/user/update" {
+		t.Errorf("shared filter: %s", got)
+	}
+	if got := sharedFilter("", "/powerbank/+/user/update"); got != "/powerbank/+/user/update" {
+		t.Errorf("unshared filter: %s", got)
+	}
+	if validateSharedGroup("a/b") == nil {
+		t.Error("group with '/': expected error")
+	}
+}
@@ -0,0 +1,103 @@
+package powerbankSdk
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// connState tracks the connection lifecycle behind ApiService.Status. Every connect,
+// loss or Disconnect starts a new generation, so work tied to one connection (such as
+// subscription retries) can tell when it is stale.
+type connState struct {
+	mu       sync.Mutex
+	status   powerbankModels.ServiceStatus
+	connects int
+	gen      int
+	changed  chan struct{} // closed when gen advances
+}
+
+// advance starts a new generation. c.mu must be held.
+func (c *connState) advance() {
+	c.gen++
+	if c.changed != nil {
+		close(c.changed)
+	}
+	c.changed = make(chan struct{})
+}
+
+// connected records a new connection, not yet subscribed, and returns its generation.
+func (c *connState) connected() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+	c.connects++
+	c.status.Connected = true
+	c.status.Subscribed = false
+	c.status.SubscribeError = nil
+	c.status.ConnectedAt = time.Now()
+	c.status.Reconnects = c.connects - 1
+	return c.gen
+}
+
+// subscribed records the outcome of subscribing on connection gen; err is nil once
+// every subscription is acknowledged.
+func (c *connState) subscribed(gen int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	c.status.Subscribed = err == nil
+	c.status.SubscribeError = err
+}
+
+// done returns a channel closed once connection gen is replaced or gone.
+func (c *connState) done(gen int) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return c.changed
+}
+
+func (c *connState) lost(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+	c.status.Connected = false
+	c.status.Subscribed = false
+	c.status.LastError = err
+}
+
+// closed records a deliberate Disconnect, which paho does not report as a loss.
+func (c *connState) closed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance()
+	c.status.Connected = false
+	c.status.Subscribed = false
+}
+
+func (c *connState) snapshot() powerbankModels.ServiceStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// callHook runs a host lifecycle hook, recovering a panic so it cannot take down the
+// paho goroutine it runs in.
+func callHook(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "[powerbank-sdk] recovered panic in %s hook: %v\n", name, r)
+		}
+	}()
+	fn()
+}
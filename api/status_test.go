@@ -0,0 +1,94 @@
+package powerbankSdk
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+func TestServerStatusFollowsConnection(t *testing.T) {
+	broker := newTestBroker(t)
+	newTestCabinet(t, broker)
+	connected := make(chan struct{}, 1)
+	lost := make(chan error, 1)
+	reconnecting := make(chan struct{}, 1)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{
+		OnConnected:      func() { connected <- struct{}{} },
+		OnConnectionLost: func(err error) { lost <- err },
+		OnReconnecting: func() {
+			select {
+			case reconnecting <- struct{}{}:
+			default:
+			}
+		},
+	})
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnConnected never ran")
+	}
+	if st := svc.Status(); !st.Ready() || st.Reconnects != 0 || st.ConnectedAt.IsZero() {
+		t.Fatalf("status after connect: %+v", st)
+	}
+
+	broker.Close()
+	select {
+	case err := <-lost:
+		if err == nil {
+			t.Error("OnConnectionLost: nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnConnectionLost never ran")
+	}
+	if st := svc.Status(); st.Connected || st.Subscribed || st.LastError == nil {
+		t.Errorf("status after loss: %+v", st)
+	}
+	select {
+	case <-reconnecting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnReconnecting never ran")
+	}
+}
+
+// TestServerRetriesRejectedSubscription has the broker refuse the heart subscription
+// (SUBACK 0x80) and checks the SDK reports it, stays not-ready, and recovers once the
+// broker allows it.
+func TestServerRetriesRejectedSubscription(t *testing.T) {
+	defer func(d time.Duration) { subscribeBackoff = d }(subscribeBackoff)
+	subscribeBackoff = 20 * time.Millisecond
+
+	broker := newTestBroker(t)
+	broker.DenySubscribe("/powerbank/+/user/heart", 0)
+	newTestCabinet(t, broker)
+	failures := make(chan error, 1)
+	connected := make(chan struct{}, 1)
+	svc := newTestServer(t, broker, powerbankModels.ServerInput{
+		OnSubscribeError: func(err error) { failures <- err },
+		OnConnected:      func() { connected <- struct{}{} },
+	})
+
+	select {
+	case err := <-failures:
+		if !errors.Is(err, ErrSubscriptionRejected) {
+			t.Errorf("OnSubscribeError: %v, want ErrSubscriptionRejected", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnSubscribeError never ran")
+	}
+	if st := svc.Status(); st.Ready() || !errors.Is(st.SubscribeError, ErrSubscriptionRejected) {
+		t.Errorf("status while denied: %+v", st)
+	}
+
+	broker.AllowSubscribe("/powerbank/+/user/heart")
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription never recovered")
+	}
+	if st := svc.Status(); !st.Ready() || st.SubscribeError != nil {
+		t.Errorf("status after recovery: %+v", st)
+	}
+}
@@ -0,0 +1,66 @@
+package powerbankSdk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSubscriptionRejected is returned (wrapped) when the broker answers a SUBSCRIBE with
+// a failure code (0x80 and above), e.g. an ACL deny or a subscription limit. Test with
+// errors.Is; on MQTT 5 the error also carries a *ReasonCodeError.
+var ErrSubscriptionRejected = errors.New("subscription rejected by broker")
+
+// subscribeAttempts is how many attempts a subscription gets before the failure is
+// reported through OnSubscribeError; retries continue after that.
+const subscribeAttempts = 3
+
+// Subscription retry backoff: the first retry waits subscribeBackoff, doubling up to
+// subscribeMaxBackoff. Variables so tests can shorten them.
+var (
+	subscribeBackoff    = time.Second
+	subscribeMaxBackoff = 30 * time.Second
+)
+
+// subscription is a topic filter the service keeps subscribed on every connection.
+type subscription struct {
+	topic   string
+	handler func(topic string, payload []byte)
+}
+
+// subscribeAll subscribes every sub on connection gen, retrying the failures with
+// backoff until all are acknowledged or the connection is lost or replaced. After
+// subscribeAttempts failed attempts the error is passed to onFailure, once. It reports
+// whether every subscription is in place.
+func (s *apiService) subscribeAll(t transport, gen int, subs []subscription, onFailure func(error)) bool {
+	delay := subscribeBackoff
+	for attempt := 1; ; attempt++ {
+		var failed []subscription
+		var errs []error
+		for _, sub := range subs {
+			if err := t.subscribe(sub); err != nil {
+				failed = append(failed, sub)
+				errs = append(errs, err)
+			}
+		}
+		err := errors.Join(errs...)
+		s.state.subscribed(gen, err)
+		if err == nil {
+			return true
+		}
+		if s.debug {
+			fmt.Printf("[mqtt] attempt %d: %v\n", attempt, err)
+		}
+		if attempt == subscribeAttempts {
+			onFailure(err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-s.state.done(gen):
+			return false
+		}
+		delay = min(2*delay, subscribeMaxBackoff)
+		subs = failed
+	}
+}
@@ -0,0 +1,87 @@
+package powerbankSdk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// tlsSchemes are the broker URL schemes paho dials over TLS.
+var tlsSchemes = map[string]bool{"ssl": true, "tls": true, "mqtts": true, "mqtt+ssl": true, "tcps": true, "wss": true}
+
+// defaultWebsocketPath is EMQX's default WebSocket listener path.
+const defaultWebsocketPath = "/mqtt"
+
+// brokerURL builds the paho broker URL from input, defaulting the scheme to tcp, or to
+// ssl when TLS settings are given, and reports whether the scheme dials TLS. WebSocket
+// schemes carry input.Path, defaulting to defaultWebsocketPath.
+func brokerURL(input powerbankModels.ServerInput) (string, bool, error) {
+	scheme := input.Scheme
+	if scheme == "" {
+		scheme = "tcp"
+		if input.TLS != nil {
+			scheme = "ssl"
+		}
+	}
+	path := ""
+	switch {
+	case scheme == "tcp" || scheme == "mqtt" || scheme == "ws":
+		if input.TLS != nil {
+			return "", false, fmt.Errorf("scheme %q does not use TLS; use ssl, mqtts or wss", scheme)
+		}
+	case tlsSchemes[scheme]:
+	default:
+		return "", false, fmt.Errorf("unsupported broker scheme %q", scheme)
+	}
+	if scheme == "ws" || scheme == "wss" {
+		path = input.Path
+		if path == "" {
+			path = defaultWebsocketPath
+		}
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	return fmt.Sprintf("%s://%s:%s%s", scheme, input.Host, input.Port, path), tlsSchemes[scheme], nil
+}
+
+// newTLSConfig turns TLSInput into a client tls.Config. ServerName defaults to host so
+// the broker certificate is verified against the name the caller dialled.
+func newTLSConfig(in *powerbankModels.TLSInput, host string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: host}
+	if in == nil {
+		return cfg, nil
+	}
+	if in.ServerName != "" {
+		cfg.ServerName = in.ServerName
+	}
+	cfg.InsecureSkipVerify = in.InsecureSkipVerify
+
+	if in.CAFile != "" {
+		pem, err := os.ReadFile(in.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", in.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if in.CertFile != "" || in.KeyFile != "" {
+		if in.CertFile == "" || in.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate needs both CertFile and KeyFile")
+		}
+		cert, err := tls.LoadX509KeyPair(in.CertFile, in.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
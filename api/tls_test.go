@@ -0,0 +1,192 @@
+package powerbankSdk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankMqttTest "github.com/techpartners-asia/powerbank/mqtttest"
+)
+
+// testPKI is a throwaway CA with one broker and one client certificate, written as PEM
+// files for TLSInput.
+type testPKI struct {
+	pool       *x509.CertPool
+	serverCert tls.Certificate
+	caFile     string
+	certFile   string
+	keyFile    string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ca key: %v", err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "powerbank test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("ca cert: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("leaf key: %v", err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			DNSNames:     []string{"localhost"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("leaf cert: %v", err)
+		}
+		return der, key
+	}
+	writePEM := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+
+	serverDER, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	clientDER, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatalf("marshal client key: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{
+		pool:       pool,
+		serverCert: tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey},
+		caFile:     writePEM("ca.pem", "CERTIFICATE", caDER),
+		certFile:   writePEM("client.pem", "CERTIFICATE", clientDER),
+		keyFile:    writePEM("client-key.pem", "EC PRIVATE KEY", clientKeyDER),
+	}
+}
+
+// TestServerMutualTLS connects NewServer to a broker that requires a client certificate
+// signed by the test CA, and checks the failure modes around it.
+func TestServerMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	broker, err := powerbankMqttTest.NewTLSBroker(&tls.Config{
+		Certificates: []tls.Certificate{pki.serverCert},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("broker: %v", err)
+	}
+	defer broker.Close()
+
+	connect := func(tlsInput *powerbankModels.TLSInput) error {
+		svc, err := NewServer(powerbankModels.ServerInput{
+			Host:   broker.Host(),
+			Port:   broker.Port(),
+			Scheme: "mqtts",
+			TLS:    tlsInput,
+		})
+		if err != nil {
+			return err
+		}
+		defer svc.Disconnect()
+		return svc.Publish(powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_CHECK})
+	}
+
+	if err := connect(&powerbankModels.TLSInput{CAFile: pki.caFile, CertFile: pki.certFile, KeyFile: pki.keyFile}); err != nil {
+		t.Errorf("mutual TLS: %v", err)
+	}
+	if err := connect(&powerbankModels.TLSInput{CAFile: pki.caFile}); err == nil {
+		t.Errorf("without client certificate: expected error")
+	}
+	if err := connect(&powerbankModels.TLSInput{CertFile: pki.certFile, KeyFile: pki.keyFile}); err == nil {
+		t.Errorf("broker not signed by a system root: expected error")
+	}
+	if err := connect(&powerbankModels.TLSInput{CertFile: pki.certFile, KeyFile: pki.keyFile, InsecureSkipVerify: true}); err != nil {
+		t.Errorf("insecure skip verify: %v", err)
+	}
+}
+
+func TestBrokerURL(t *testing.T) {
+	cases := []struct {
+		scheme  string
+		tls     *powerbankModels.TLSInput
+		want    string
+		wantTLS bool
+		wantErr bool
+	}{
+		{"", nil, "tcp://h:1883", false, false},
+		{"", &powerbankModels.TLSInput{}, "ssl://h:1883", true, false},
+		{"mqtts", nil, "mqtts://h:1883", true, false},
+		{"tcp", &powerbankModels.TLSInput{}, "", false, true},
+		{"ws", nil, "ws://h:1883/mqtt", false, false},
+		{"wss", &powerbankModels.TLSInput{}, "wss://h:1883/mqtt", true, false},
+		{"ws", &powerbankModels.TLSInput{}, "", false, true},
+		{"gopher", nil, "", false, true},
+	}
+	for _, tc := range cases {
+		got, gotTLS, err := brokerURL(powerbankModels.ServerInput{Host: "h", Port: "1883", Scheme: tc.scheme, TLS: tc.tls})
+		if (err != nil) != tc.wantErr || got != tc.want || gotTLS != tc.wantTLS {
+			t.Errorf("scheme %q tls %v: got (%q, %v, %v) want (%q, %v, err=%v)", tc.scheme, tc.tls != nil, got, gotTLS, err, tc.want, tc.wantTLS, tc.wantErr)
+		}
+	}
+}
+
+func TestServerOverWebsocket(t *testing.T) {
+	broker, err := powerbankMqttTest.NewWebsocketBroker()
+	if err != nil {
+		t.Fatalf("broker: %v", err)
+	}
+	defer broker.Close()
+
+	svc, err := NewServer(powerbankModels.ServerInput{Host: broker.Host(), Port: broker.Port(), Scheme: "ws"})
+	if err != nil {
+		t.Fatalf("NewServer over ws: %v", err)
+	}
+	defer svc.Disconnect()
+	if err := svc.Publish(powerbankModels.PublishInput{ClientID: testDeviceID, PublishType: constants.PUBLISH_TYPE_CHECK}); err != nil {
+		t.Errorf("publish over ws: %v", err)
+	}
+}
+
+func TestBrokerURLWebsocketPath(t *testing.T) {
+	got, _, err := brokerURL(powerbankModels.ServerInput{Host: "h", Port: "8083", Scheme: "ws", Path: "emqx/mqtt"})
+	if err != nil || got != "ws://h:8083/emqx/mqtt" {
+		t.Errorf("got (%q, %v) want ws://h:8083/emqx/mqtt", got, err)
+	}
+}
@@ -0,0 +1,112 @@
+package powerbankSdk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankUtils "github.com/techpartners-asia/powerbank/utils"
+)
+
+// ErrDeviceLayoutUnknown is returned when a service serving several topic layouts is
+// asked to publish to a device it has not heard from yet and PublishInput.Layout does
+// not name one.
+var ErrDeviceLayoutUnknown = errors.New("topic layout of device unknown")
+
+// ErrSharedSubscription is returned by PublishAndWait on a service with a SharedGroup:
+// the broker may hand the cabinet's reply to another replica, so it cannot be awaited.
+var ErrSharedSubscription = errors.New("replies cannot be awaited with a shared subscription")
+
+// defaultTopicLayout is the layout used when ServerInput.Topics is empty.
+var defaultTopicLayout = powerbankModels.TopicLayout{
+	Publish: constants.TOPIC_TEMPLATE_PUBLISH,
+	Update:  constants.TOPIC_TEMPLATE_UPDATE,
+	Heart:   constants.TOPIC_TEMPLATE_HEART,
+}
+
+// topicLayouts holds the configured layouts and remembers which one each device was
+// last heard on, so replies go back under the device's own prefix.
+type topicLayouts struct {
+	layouts []powerbankModels.TopicLayout
+
+	mu      sync.Mutex
+	devices map[string]int // device ID -> index into layouts
+}
+
+func newTopicLayouts(layouts []powerbankModels.TopicLayout) (*topicLayouts, error) {
+	if len(layouts) == 0 {
+		layouts = []powerbankModels.TopicLayout{defaultTopicLayout}
+	}
+	names := make(map[string]bool)
+	filters := make(map[string]bool)
+	for _, l := range layouts {
+		for _, template := range []string{l.Publish, l.Update, l.Heart} {
+			if err := powerbankUtils.ValidateTopicTemplate(template); err != nil {
+				return nil, err
+			}
+		}
+		if l.Name != "" {
+			if names[l.Name] {
+				return nil, fmt.Errorf("topic layout %q configured twice", l.Name)
+			}
+			names[l.Name] = true
+		}
+		for _, template := range []string{l.Update, l.Heart} {
+			filter := powerbankUtils.TopicFilter(template)
+			if filters[filter] {
+				return nil, fmt.Errorf("topic %q subscribed by two layouts", template)
+			}
+			filters[filter] = true
+		}
+	}
+	return &topicLayouts{layouts: layouts, devices: make(map[string]int)}, nil
+}
+
+// heardFrom records that deviceID published under layout i.
+func (t *topicLayouts) heardFrom(deviceID string, i int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.devices[deviceID] = i
+}
+
+// publishTopic returns the topic to send deviceID a command on: under the layout named
+// layout if set, else the one the device was last heard on, else the only layout.
+func (t *topicLayouts) publishTopic(deviceID, layout string) (string, error) {
+	if layout != "" {
+		for _, l := range t.layouts {
+			if l.Name == layout {
+				return powerbankUtils.FormatTopic(l.Publish, deviceID), nil
+			}
+		}
+		return "", fmt.Errorf("no topic layout named %q", layout)
+	}
+	if len(t.layouts) == 1 {
+		return powerbankUtils.FormatTopic(t.layouts[0].Publish, deviceID), nil
+	}
+	t.mu.Lock()
+	i, ok := t.devices[deviceID]
+	t.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%s: %w; set PublishInput.Layout", deviceID, ErrDeviceLayoutUnknown)
+	}
+	return powerbankUtils.FormatTopic(t.layouts[i].Publish, deviceID), nil
+}
+
+// sharedFilter returns filter as an MQTT shared subscription of group, or filter itself
+// when group is empty.
+func sharedFilter(group, filter string) string {
+	if group == "" {
+		return filter
+	}
+	return "$share/" + group + "/" + filter
+}
+
+func validateSharedGroup(group string) error {
+	if strings.ContainsAny(group, "/+#") {
+		return fmt.Errorf("shared subscription group %q must not contain '/', '+' or '#'", group)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package powerbankSdk
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankSimulator "github.com/techpartners-asia/powerbank/simulator"
+)
+
+func operatorLayout(name string) powerbankModels.TopicLayout {
+	return powerbankModels.TopicLayout{
+		Name:    name,
+		Publish: "/" + name + "/powerbank/{device}/user/get",
+		Update:  "/" + name + "/powerbank/{device}/user/update",
+		Heart:   "/" + name + "/powerbank/{device}/user/heart",
+	}
+}
+
+// TestServerServesSeveralTopicLayouts runs one service over two operators' prefixes on
+// one broker, with a cabinet under each.
+func TestServerServesSeveralTopicLayouts(t *testing.T) {
+	broker := newTestBroker(t)
+	acme, globex := operatorLayout("acme"), operatorLayout("globex")
+	cabinets := map[string]powerbankModels.TopicLayout{"860000000000001": acme, "860000000000002": globex}
+	for deviceID, layout := range cabinets {
+		sim, err := powerbankSimulator.NewSimulator(powerbankModels.SimulatorInput{
+			Host:              broker.Host(),
+			Port:              broker.Port(),
+			DeviceID:          deviceID,
+			HeartbeatInterval: -1,
+			Topics:            layout,
+		})
+		if err != nil {
+			t.Fatalf("simulator: %v", err)
+		}
+		t.Cleanup(sim.Close)
+	}
+
+	svc, err := NewServer(powerbankModels.ServerInput{
+		Host:   broker.Host(),
+		Port:   broker.Port(),
+		Topics: []powerbankModels.TopicLayout{acme, globex},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(svc.Disconnect)
+
+	err = svc.Publish(powerbankModels.PublishInput{ClientID: "860000000000001", PublishType: constants.PUBLISH_TYPE_CHECK})
+	if !errors.Is(err, ErrDeviceLayoutUnknown) {
+		t.Fatalf("publish to an unseen device: got %v, want ErrDeviceLayoutUnknown", err)
+	}
+	for deviceID, layout := range cabinets {
+		// The first check names the layout; once the reply is heard the device's layout
+		// is known and the second needs none.
+		awaitCheck(t, svc, powerbankModels.PublishInput{ClientID: deviceID, Layout: layout.Name})
+		awaitCheck(t, svc, powerbankModels.PublishInput{ClientID: deviceID})
+	}
+}
+
+func TestNewTopicLayoutsValidates(t *testing.T) {
+	bad := operatorLayout("acme")
+	bad.Update = "/acme/powerbank/+/user/update"
+	renamed := operatorLayout("acme")
+	renamed.Name = "other"
+	for name, layouts := range map[string][]powerbankModels.TopicLayout{
+		"wildcard":       {bad},
+		"duplicate name": {operatorLayout("acme"), operatorLayout("acme")},
+		"same filters":   {operatorLayout("acme"), renamed},
+	} {
+		if _, err := newTopicLayouts(layouts); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
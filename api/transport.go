@@ -0,0 +1,70 @@
+package powerbankSdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// ErrRequiresMQTT5 is returned by Publish when PublishInput carries MQTT 5 properties
+// but the service speaks MQTT 3.1.1.
+var ErrRequiresMQTT5 = errors.New("MQTT 5 publish properties require ProtocolVersion 5")
+
+// ReasonCodeError is an MQTT 5 failure reason code (0x80 and above) the broker
+// answered or closed with, e.g. 0x87 Not authorized on a SUBACK or PUBACK.
+type ReasonCodeError struct {
+	Packet string // "SUBACK", "PUBACK" or "DISCONNECT"
+	Code   byte
+	Reason string // the broker's reason string, if it sent one
+}
+
+func (e *ReasonCodeError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("%s reason code 0x%02X: %s", e.Packet, e.Code, e.Reason)
+	}
+	return fmt.Sprintf("%s reason code 0x%02X", e.Packet, e.Code)
+}
+
+// transport is the MQTT client behind apiService: paho.mqtt.golang for MQTT 3.1.1
+// (transport_v3.go) or paho.golang for MQTT 5 (transport_v5.go).
+type transport interface {
+	// subscribe subscribes at QoS 0 and verifies the broker's acknowledgement.
+	subscribe(sub subscription) error
+	// publish returns once msg is written (QoS 0) or acknowledged (QoS 1), or ctx is done.
+	publish(ctx context.Context, msg outbound) error
+	disconnect()
+}
+
+// outbound is one command on its way to a cabinet.
+type outbound struct {
+	topic   string
+	payload []byte
+	qos     byte
+	expiry  uint32                       // MQTT 5 message expiry interval in seconds; 0 for none
+	input   powerbankModels.PublishInput // source of the MQTT 5 properties
+}
+
+// connEvents are the connection lifecycle callbacks a transport drives.
+type connEvents struct {
+	up           func(t transport) // connected (again); may block to subscribe
+	down         func(err error)   // connection lost
+	reconnecting func()            // about to retry the connection
+}
+
+// hasV5Properties reports whether input sets any MQTT 5 publish property.
+func hasV5Properties(input powerbankModels.PublishInput) bool {
+	return len(input.UserProperties) > 0 || input.ResponseTopic != "" || input.CorrelationData != nil
+}
+
+// idempotent reports whether a repeated delivery of the command is harmless, i.e. it
+// may go at QoS 1. Dispenses and reboot are not.
+func idempotent(typ constants.PUBLISH_TYPE) bool {
+	switch typ {
+	case constants.PUBLISH_TYPE_CHECK, constants.PUBLISH_TYPE_UPLOAD, constants.PUBLISH_TYPE_LOAD_AD:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package powerbankSdk
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// v3Transport speaks MQTT 3.1.1 through paho.mqtt.golang.
+type v3Transport struct {
+	client mqtt.Client
+}
+
+func newV3Transport(input powerbankModels.ServerInput, broker string, tlsConfig *tls.Config, events connEvents) (*v3Transport, error) {
+	if input.Debug {
+		mqtt.DEBUG = log.New(os.Stdout, "[mqtt] ", log.LstdFlags)
+		mqtt.ERROR = log.New(os.Stderr, "[mqtt-err] ", log.LstdFlags)
+	}
+
+	t := &v3Transport{}
+	opts := mqtt.NewClientOptions().AddBroker(broker)
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetUsername(input.Username)
+	opts.SetPassword(input.Password)
+	opts.SetKeepAlive(30 * time.Second)
+	opts.SetPingTimeout(10 * time.Second)
+	// Auto-reconnect dropped sessions and cap the backoff — paho's 10m default is
+	// far too slow to recover from a blip.
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(30 * time.Second)
+
+	// Subscribe INSIDE OnConnect so subscriptions are (re)established on every
+	// connect AND auto-reconnect. With CleanSession=true (paho default) the broker
+	// discards subscriptions on disconnect; subscribing only once after Connect()
+	// leaves an auto-reconnected client "connected" but receiving nothing — the
+	// silent half-dead state that stops popup/check/return callbacks. This is the fix.
+	opts.SetOnConnectHandler(func(mqtt.Client) {
+		events.up(t)
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		if input.Debug {
+			fmt.Printf("[mqtt] connection lost: %v\n", err)
+		}
+		events.down(err)
+	})
+	opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+		events.reconnecting()
+	})
+
+	if input.Debug {
+		opts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
+			fmt.Printf("TOPIC: %s\n", msg.Topic())
+			fmt.Printf("MSG: %s\n", msg.Payload())
+		})
+	}
+
+	t.client = mqtt.NewClient(opts)
+	// Block on the initial connect so callers still get an error if the broker is
+	// unreachable at startup; OnConnect handles (re)subscription from here on.
+	if token := t.client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("mqtt connect: %w", token.Error())
+	}
+	return t, nil
+}
+
+func (t *v3Transport) subscribe(sub subscription) error {
+	token := t.client.Subscribe(sub.topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+		sub.handler(msg.Topic(), msg.Payload())
+	})
+	if !token.WaitTimeout(subscribeTimeout) {
+		return fmt.Errorf("subscribe %s: no SUBACK within %v", sub.topic, subscribeTimeout)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("subscribe %s: %w", sub.topic, err)
+	}
+	// One filter per SUBSCRIBE, so one return code; paho keys it by the filter without
+	// any $share/{group}/ prefix.
+	for _, code := range token.(*mqtt.SubscribeToken).Result() {
+		if code > 2 {
+			return fmt.Errorf("subscribe %s: SUBACK 0x%02X: %w", sub.topic, code, ErrSubscriptionRejected)
+		}
+		return nil
+	}
+	return fmt.Errorf("subscribe %s: SUBACK without a return code: %w", sub.topic, ErrSubscriptionRejected)
+}
+
+func (t *v3Transport) publish(ctx context.Context, msg outbound) error {
+	token := t.client.Publish(msg.topic, msg.qos, false, msg.payload)
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		// The message may still go out; only the wait is abandoned.
+		return ctx.Err()
+	}
+	return token.Error()
+}
+
+func (t *v3Transport) disconnect() {
+	// Unconditional (not only when IsConnected): a client mid-auto-reconnect
+	// reports IsConnected()==false but still runs a background reconnect
+	// goroutine; Disconnect tears it down. Guarding on IsConnected would leak it.
+	t.client.Disconnect(250)
+}
@@ -0,0 +1,256 @@
+package powerbankSdk
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// v5Transport speaks MQTT 5 through paho.golang's autopaho, which reconnects on its own.
+type v5Transport struct {
+	manager *autopaho.ConnectionManager
+	events  connEvents
+
+	mu            sync.Mutex
+	cm            *autopaho.ConnectionManager // set once the first connection is up
+	up            bool                        // a connection is up and its loss not yet reported
+	everConnected bool
+	routes        map[string]func(topic string, payload []byte) // by subscribed filter
+}
+
+func newV5Transport(input powerbankModels.ServerInput, broker string, tlsConfig *tls.Config, events connEvents) (*v5Transport, error) {
+	serverURL, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt broker url: %w", err)
+	}
+	t := &v5Transport{events: events, routes: make(map[string]func(string, []byte))}
+
+	connected := make(chan struct{})
+	var connectedOnce sync.Once
+	connectErr := make(chan error, 1)
+
+	cfg := autopaho.ClientConfig{
+		ServerUrls: []*url.URL{serverURL},
+		TlsCfg:     tlsConfig,
+		KeepAlive:  30,
+		// Clean start with no session expiry, like the 3.1.1 client: subscriptions are
+		// re-established on every connection (see OnConnectionUp).
+		CleanStartOnInitialConnection: true,
+		ReconnectBackoff:              t.backoff,
+		ConnectTimeout:                10 * time.Second,
+		ConnectUsername:               input.Username,
+		ConnectPassword:               []byte(input.Password),
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+			t.mu.Lock()
+			t.cm = cm
+			t.up = true
+			t.everConnected = true
+			t.mu.Unlock()
+			connectedOnce.Do(func() { close(connected) })
+			// Must not block: subscribing waits for SUBACKs.
+			go events.up(t)
+		},
+		OnConnectError: func(err error) {
+			select {
+			case connectErr <- err:
+			default:
+			}
+		},
+		ClientConfig: paho.ClientConfig{
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){t.route},
+			OnClientError:     t.lost,
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				err := &ReasonCodeError{Packet: "DISCONNECT", Code: d.ReasonCode}
+				if d.Properties != nil {
+					err.Reason = d.Properties.ReasonString
+				}
+				t.lost(err)
+			},
+		},
+	}
+	if input.Debug {
+		cfg.Debug = log.New(os.Stdout, "[mqtt] ", log.LstdFlags)
+		cfg.Errors = log.New(os.Stderr, "[mqtt-err] ", log.LstdFlags)
+	}
+
+	t.manager, err = autopaho.NewConnection(context.Background(), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt connect: %w", err)
+	}
+	// Fail on the first refused attempt, as the 3.1.1 client does, rather than
+	// retrying in the background while the caller waits.
+	select {
+	case <-connected:
+		return t, nil
+	case err := <-connectErr:
+		t.disconnect()
+		return nil, fmt.Errorf("mqtt connect: %w", err)
+	}
+}
+
+// backoff is autopaho's wait before connection attempt n of a cycle: none for the
+// first, then 1s doubling up to 30s, matching the 3.1.1 client's cap.
+func (t *v5Transport) backoff(attempt int) time.Duration {
+	t.mu.Lock()
+	reconnecting := t.everConnected
+	t.mu.Unlock()
+	if reconnecting {
+		t.events.reconnecting()
+	}
+	if attempt == 0 {
+		return 0
+	}
+	return min(time.Second<<min(attempt-1, 5), 30*time.Second)
+}
+
+// lost reports the loss of the current connection, once.
+func (t *v5Transport) lost(err error) {
+	t.mu.Lock()
+	up := t.up
+	t.up = false
+	t.mu.Unlock()
+	if up {
+		t.events.down(err)
+	}
+}
+
+// route hands a received message to every subscription whose filter matches it.
+func (t *v5Transport) route(pr paho.PublishReceived) (bool, error) {
+	t.mu.Lock()
+	var handlers []func(string, []byte)
+	for filter, h := range t.routes {
+		if topicMatches(filter, pr.Packet.Topic) {
+			handlers = append(handlers, h)
+		}
+	}
+	t.mu.Unlock()
+	for _, h := range handlers {
+		h(pr.Packet.Topic, pr.Packet.Payload)
+	}
+	return len(handlers) > 0, nil
+}
+
+func (t *v5Transport) connection() (*autopaho.ConnectionManager, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cm == nil {
+		return nil, autopaho.ConnectionDownError
+	}
+	return t.cm, nil
+}
+
+func (t *v5Transport) subscribe(sub subscription) error {
+	cm, err := t.connection()
+	if err != nil {
+		return fmt.Errorf("subscribe %s: %w", sub.topic, err)
+	}
+	t.mu.Lock()
+	t.routes[sub.topic] = sub.handler
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+	defer cancel()
+	suback, err := cm.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: sub.topic, QoS: 0}},
+	})
+	// paho reports a failure reason code as a plain error; surface the code itself.
+	if suback != nil && len(suback.Reasons) == 1 && suback.Reasons[0] >= 0x80 {
+		rc := &ReasonCodeError{Packet: "SUBACK", Code: suback.Reasons[0]}
+		if suback.Properties != nil {
+			rc.Reason = suback.Properties.ReasonString
+		}
+		return fmt.Errorf("subscribe %s: %w: %w", sub.topic, ErrSubscriptionRejected, rc)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("subscribe %s: no SUBACK within %v", sub.topic, subscribeTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("subscribe %s: %w", sub.topic, err)
+	}
+	if suback == nil || len(suback.Reasons) == 0 {
+		return fmt.Errorf("subscribe %s: SUBACK without a reason code: %w", sub.topic, ErrSubscriptionRejected)
+	}
+	return nil
+}
+
+func (t *v5Transport) publish(ctx context.Context, msg outbound) error {
+	cm, err := t.connection()
+	if err != nil {
+		return err
+	}
+	res, err := cm.Publish(ctx, &paho.Publish{
+		QoS:        msg.qos,
+		Topic:      msg.topic,
+		Payload:    msg.payload,
+		Properties: publishProperties(msg),
+	})
+	if res != nil && res.ReasonCode >= 0x80 {
+		rc := &ReasonCodeError{Packet: "PUBACK", Code: res.ReasonCode}
+		if res.Properties != nil {
+			rc.Reason = res.Properties.ReasonString
+		}
+		return rc
+	}
+	return err
+}
+
+func (t *v5Transport) disconnect() {
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	_ = t.manager.Disconnect(ctx)
+}
+
+// publishProperties maps msg's expiry and the MQTT 5 fields of its input to PUBLISH
+// properties, user properties in key order so packets are reproducible.
+func publishProperties(msg outbound) *paho.PublishProperties {
+	input := msg.input
+	props := &paho.PublishProperties{
+		ResponseTopic:   input.ResponseTopic,
+		CorrelationData: input.CorrelationData,
+	}
+	if msg.expiry > 0 {
+		props.MessageExpiry = &msg.expiry
+	}
+	keys := make([]string, 0, len(input.UserProperties))
+	for k := range input.UserProperties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		props.User.Add(k, input.UserProperties[k])
+	}
+	return props
+}
+
+// topicMatches reports whether topic matches the subscription filter, which may carry
+// a $share/{group}/ prefix and the + and # wildcards.
+func topicMatches(filter, topic string) bool {
+	if rest, ok := strings.CutPrefix(filter, "$share/"); ok {
+		if _, f, ok := strings.Cut(rest, "/"); ok {
+			filter = f
+		}
+	}
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) || (f != "+" && f != tl[i]) {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
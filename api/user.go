@@ -2,7 +2,9 @@ package powerbankSdk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,12 +15,69 @@ import (
 
 // userHTTPTimeout bounds every EMQX management API call. GetUser runs on the dispense
 // gate (IsDeviceOnline), so an unbounded call could stall a dispense; this timeout
-// guarantees it cannot.
+// guarantees it cannot. The *Context methods can only shorten it.
 const userHTTPTimeout = 10 * time.Second
 
+// Sentinels an *EMQXError matches with errors.Is, by HTTP status. IsDeviceOnline keeps
+// them apart from "offline" so a misconfigured API key is not mistaken for a fleet
+// that is down.
+var (
+	ErrEMQXUnauthorized     = errors.New("emqx api: unauthorized, check ApiKey and ApiSecret") // 401
+	ErrEMQXForbidden        = errors.New("emqx api: forbidden")                                // 403
+	ErrEMQXNotFound         = errors.New("emqx api: not found")                                // 404
+	ErrEMQXUnavailable      = errors.New("emqx api: server error")                             // 5xx
+	ErrEMQXUnexpectedStatus = errors.New("emqx api: unexpected status")                        // any other non-2xx
+	ErrEMQXBadResponse      = errors.New("emqx api: undecodable response")
+)
+
+// EMQXError is a non-2xx answer from the EMQX management API, with the error body EMQX
+// sends, e.g. {"code":"ALREADY_EXISTS","message":"User already exists"}. Code and
+// Message are empty when the body is not one.
+type EMQXError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *EMQXError) Error() string {
+	msg := fmt.Sprintf("emqx api: status %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is matches the sentinel for e's status, e.g. ErrEMQXUnauthorized for a 401.
+func (e *EMQXError) Is(target error) bool {
+	return target == statusError(e.StatusCode)
+}
+
+// newEMQXError reads the error body of a non-2xx resp.
+func newEMQXError(resp *http.Response) *EMQXError {
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	return &EMQXError{StatusCode: resp.StatusCode, Code: body.Code, Message: body.Message}
+}
+
 type UserService interface {
 	AddUser(deviceId string, password string, database string) (*powerbankModels.CreateUserResponse, error)
 	GetUser(deviceId string) (*powerbankModels.GetUserResponse, error)
+	// AddUserContext and GetUserContext are AddUser and GetUser bounded by ctx, which
+	// cancels the HTTP request and carries request-scoped values to the transport.
+	AddUserContext(ctx context.Context, deviceId string, password string, database string) (*powerbankModels.CreateUserResponse, error)
+	GetUserContext(ctx context.Context, deviceId string) (*powerbankModels.GetUserResponse, error)
+	// IsDeviceOnline reports whether the cabinet is connected to EMQX. An unknown
+	// client (404) is offline; any other failure is an error (an *EMQXError matching
+	// ErrEMQXUnauthorized, ErrEMQXForbidden, ErrEMQXUnavailable or
+	// ErrEMQXUnexpectedStatus, ErrEMQXBadResponse or a transport error), never a silent
+	// "offline". UserInput.LenientStatus does not apply.
+	IsDeviceOnline(ctx context.Context, deviceId string) (bool, error)
 }
 
 type userService struct {
@@ -27,6 +86,7 @@ type userService struct {
 	baseURL   string
 	apiKey    string
 	apiSecret string
+	lenient   bool
 	client    *http.Client
 }
 
@@ -35,51 +95,65 @@ func NewUserService(input powerbankModels.UserInput) UserService {
 		baseURL:   fmt.Sprintf("http://%s:%s", input.Host, input.Port),
 		apiKey:    input.ApiKey,
 		apiSecret: input.ApiSecret,
+		lenient:   input.LenientStatus,
 		client:    &http.Client{Timeout: userHTTPTimeout},
 	}
 }
 
 // do issues an EMQX management API request with basic auth and decodes the JSON
-// response body into out. Semantics deliberately match the prior client: a non-2xx
-// status is NOT treated as an error (the body is still decoded) — e.g. GetUser on a
-// 404 yields a zero-valued response (Connected=false), which IsDeviceOnline reads as
-// "offline". Only transport and decode failures return an error.
-func (s *userService) do(method, path string, body, out any) error {
+// response body into out. A non-2xx status returns an *EMQXError; with
+// UserInput.LenientStatus it is not an error and the body is decoded anyway, as the
+// prior client did — e.g. GetUser on a 404 yields a zero-valued response
+// (Connected=false).
+func (s *userService) do(ctx context.Context, method, path string, body, out any) error {
+	resp, err := s.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !s.lenient && statusError(resp.StatusCode) != nil {
+		return newEMQXError(resp)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// send issues an EMQX management API request with basic auth. The caller closes the
+// response body.
+func (s *userService) send(ctx context.Context, method, path string, body any) (*http.Response, error) {
 	var reqBody io.Reader
 	if body != nil {
 		b, err := json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("marshal request: %w", err)
+			return nil, fmt.Errorf("marshal request: %w", err)
 		}
 		reqBody = bytes.NewReader(b)
 	}
 
-	req, err := http.NewRequest(method, s.baseURL+path, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reqBody)
 	if err != nil {
-		return fmt.Errorf("new request: %w", err)
+		return nil, fmt.Errorf("new request: %w", err)
 	}
 	req.SetBasicAuth(s.apiKey, s.apiSecret)
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if out != nil {
-		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
-			return fmt.Errorf("decode response: %w", err)
-		}
-	}
-	return nil
+	return s.client.Do(req)
 }
 
 func (s *userService) AddUser(deviceId string, password string, database string) (*powerbankModels.CreateUserResponse, error) {
+	return s.AddUserContext(context.Background(), deviceId, password, database)
+}
+
+func (s *userService) AddUserContext(ctx context.Context, deviceId string, password string, database string) (*powerbankModels.CreateUserResponse, error) {
 	var data powerbankModels.CreateUserResponse
-	if err := s.do(http.MethodPost, fmt.Sprintf("/api/v5/authentication/%s/users", database), map[string]interface{}{
+	if err := s.do(ctx, http.MethodPost, fmt.Sprintf("/api/v5/authentication/%s/users", database), map[string]interface{}{
 		"user_id":  deviceId,
 		"password": password,
 	}, &data); err != nil {
@@ -89,9 +163,51 @@ func (s *userService) AddUser(deviceId string, password string, database string)
 }
 
 func (s *userService) GetUser(deviceId string) (*powerbankModels.GetUserResponse, error) {
+	return s.GetUserContext(context.Background(), deviceId)
+}
+
+func (s *userService) GetUserContext(ctx context.Context, deviceId string) (*powerbankModels.GetUserResponse, error) {
 	var data powerbankModels.GetUserResponse
-	if err := s.do(http.MethodGet, fmt.Sprintf("/api/v5/clients/%s", deviceId), nil, &data); err != nil {
+	if err := s.do(ctx, http.MethodGet, fmt.Sprintf("/api/v5/clients/%s", deviceId), nil, &data); err != nil {
 		return nil, fmt.Errorf("emqx get user: %w", err)
 	}
 	return &data, nil
 }
+
+func (s *userService) IsDeviceOnline(ctx context.Context, deviceId string) (bool, error) {
+	resp, err := s.send(ctx, http.MethodGet, fmt.Sprintf("/api/v5/clients/%s", deviceId), nil)
+	if err != nil {
+		return false, fmt.Errorf("emqx is device online %s: %w", deviceId, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if statusError(resp.StatusCode) != nil {
+		return false, fmt.Errorf("emqx is device online %s: %w", deviceId, newEMQXError(resp))
+	}
+	var data powerbankModels.GetUserResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return false, fmt.Errorf("emqx is device online %s: %w: %w", deviceId, ErrEMQXBadResponse, err)
+	}
+	return data.Connected, nil
+}
+
+// statusError maps a non-2xx EMQX API status to its sentinel error.
+func statusError(status int) error {
+	switch {
+	case status >= 200 && status < 300:
+		return nil
+	case status == http.StatusUnauthorized:
+		return ErrEMQXUnauthorized
+	case status == http.StatusForbidden:
+		return ErrEMQXForbidden
+	case status == http.StatusNotFound:
+		return ErrEMQXNotFound
+	case status >= 500:
+		return ErrEMQXUnavailable
+	default:
+		return ErrEMQXUnexpectedStatus
+	}
+}
@@ -1,12 +1,15 @@
 package powerbankSdk
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"sync"
 	"testing"
+	"time"
 
 	powerbankModels "github.com/techpartners-asia/powerbank/models"
 )
@@ -40,3 +43,137 @@ func TestUserServiceConcurrentNoRace(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+// TestUserServiceContextCancels checks that GetUserContext gives up when its ctx does,
+// well before userHTTPTimeout, instead of waiting on a stalled EMQX.
+func TestUserServiceContextCancels(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("parse test server url: %v", err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatalf("split host:port: %v", err)
+	}
+	svc := NewUserService(powerbankModels.UserInput{Host: host, Port: port, ApiKey: "k", ApiSecret: "s"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := svc.GetUserContext(ctx, "dev"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetUserContext took %v after its ctx expired", elapsed)
+	}
+}
+
+// newTestUserService returns a UserService talking to handler, filling in the
+// connection fields of input.
+func newTestUserService(t *testing.T, input powerbankModels.UserInput, handler http.HandlerFunc) UserService {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("parse test server url: %v", err)
+	}
+	if input.Host, input.Port, err = net.SplitHostPort(u.Host); err != nil {
+		t.Fatalf("split host:port: %v", err)
+	}
+	return NewUserService(input)
+}
+
+func TestUserServiceIsDeviceOnline(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		online bool
+		err    error
+	}{
+		{"connected", http.StatusOK, `{"clientid":"dev","connected":true}`, true, nil},
+		{"disconnected", http.StatusOK, `{"clientid":"dev","connected":false}`, false, nil},
+		{"unknown client", http.StatusNotFound, `{"code":"CLIENTID_NOT_FOUND","message":"Client ID not found"}`, false, nil},
+		{"bad api key", http.StatusUnauthorized, `{"code":"BAD_API_KEY_OR_SECRET","message":"Check api_key/api_secret"}`, false, ErrEMQXUnauthorized},
+		{"forbidden", http.StatusForbidden, `{"code":"API_KEY_NOT_ALLOW","message":"not allowed"}`, false, ErrEMQXForbidden},
+		{"broker down", http.StatusServiceUnavailable, ``, false, ErrEMQXUnavailable},
+		{"bad request", http.StatusBadRequest, `{"code":"BAD_REQUEST","message":"bad"}`, false, ErrEMQXUnexpectedStatus},
+		{"garbage", http.StatusOK, `<html>proxy error</html>`, false, ErrEMQXBadResponse},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := newTestUserService(t, powerbankModels.UserInput{}, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v5/clients/dev" {
+					t.Errorf("path %s", r.URL.Path)
+				}
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			})
+			online, err := svc.IsDeviceOnline(context.Background(), "dev")
+			if online != tc.online || !errors.Is(err, tc.err) || (tc.err == nil && err != nil) {
+				t.Errorf("got (%v, %v), want (%v, %v)", online, err, tc.online, tc.err)
+			}
+		})
+	}
+}
+
+func TestUserServiceEMQXError(t *testing.T) {
+	status, body := http.StatusConflict, `{"code":"ALREADY_EXISTS","message":"User already exists"}`
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}
+	svc := newTestUserService(t, powerbankModels.UserInput{}, handler)
+
+	_, err := svc.AddUser("dev", "pw", "password_based:built_in_database")
+	var apiErr *EMQXError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict || apiErr.Code != "ALREADY_EXISTS" || apiErr.Message != "User already exists" {
+		t.Fatalf("AddUser existing: got %v, want ALREADY_EXISTS", err)
+	}
+	if !errors.Is(err, ErrEMQXUnexpectedStatus) || errors.Is(err, ErrEMQXUnauthorized) {
+		t.Errorf("AddUser existing: %v matches the wrong sentinels", err)
+	}
+
+	status, body = http.StatusBadRequest, `{"code":"BAD_REQUEST","message":"password is required"}`
+	if _, err := svc.AddUser("dev", "", "password_based:built_in_database"); !errors.As(err, &apiErr) || apiErr.Code != "BAD_REQUEST" {
+		t.Errorf("AddUser without password: got %v, want BAD_REQUEST", err)
+	}
+
+	status, body = http.StatusUnauthorized, `{"code":"BAD_API_KEY_OR_SECRET","message":"Check api_key/api_secret"}`
+	_, err = svc.GetUser("dev")
+	if !errors.Is(err, ErrEMQXUnauthorized) || !errors.As(err, &apiErr) || apiErr.Code != "BAD_API_KEY_OR_SECRET" {
+		t.Errorf("GetUser with a bad key: got %v, want ErrEMQXUnauthorized", err)
+	}
+	if _, err := svc.IsDeviceOnline(context.Background(), "dev"); !errors.As(err, &apiErr) || apiErr.Code != "BAD_API_KEY_OR_SECRET" {
+		t.Errorf("IsDeviceOnline with a bad key: got %v, want an *EMQXError", err)
+	}
+
+	status, body = http.StatusBadGateway, `<html>bad gateway</html>`
+	if _, err := svc.GetUser("dev"); !errors.Is(err, ErrEMQXUnavailable) || !errors.As(err, &apiErr) || apiErr.Code != "" {
+		t.Errorf("GetUser behind a failing proxy: got %v, want ErrEMQXUnavailable", err)
+	}
+}
+
+func TestUserServiceLenientStatus(t *testing.T) {
+	notFound := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"code":"CLIENTID_NOT_FOUND","message":"Client ID not found"}`))
+	}
+
+	lenient := newTestUserService(t, powerbankModels.UserInput{LenientStatus: true}, notFound)
+	if user, err := lenient.GetUser("dev"); err != nil || user.Connected {
+		t.Errorf("lenient GetUser of an unknown client: got (%+v, %v), want a zero response", user, err)
+	}
+	strict := newTestUserService(t, powerbankModels.UserInput{}, notFound)
+	if _, err := strict.GetUser("dev"); !errors.Is(err, ErrEMQXNotFound) {
+		t.Errorf("GetUser of an unknown client: got %v, want ErrEMQXNotFound", err)
+	}
+}
@@ -1,6 +1,14 @@
 package constants
 
-const ()
+import "time"
+
+// Cabinet liveness. The cabinet sends a 0x7A heartbeat every HEARTBEAT_INTERVAL
+// (https://docs.volinks.com/powerbank-protocol-v1/en/guide/protocol-heart.html); one
+// silent for OFFLINE_AFTER, a heartbeat plus a minute of slack, counts as offline.
+const (
+	HEARTBEAT_INTERVAL = 9 * time.Minute
+	OFFLINE_AFTER      = HEARTBEAT_INTERVAL + time.Minute
+)
 
 type TOPIC string
 
@@ -10,6 +18,16 @@ const (
 	TOPIC_HEALTH_CHECK TOPIC = "/powerbank/%s/user/heart"
 )
 
+// Topic templates of the default layout, for ServerInput.Topics. {device} stands for
+// the cabinet's device ID (EMQX Client ID) and must fill one whole topic level.
+const (
+	TOPIC_DEVICE_PLACEHOLDER = "{device}"
+
+	TOPIC_TEMPLATE_PUBLISH = "/powerbank/{device}/user/get"
+	TOPIC_TEMPLATE_UPDATE  = "/powerbank/{device}/user/update"
+	TOPIC_TEMPLATE_HEART   = "/powerbank/{device}/user/heart"
+)
+
 type PUBLISH_TYPE string
 
 // Volinks Powerbank Protocol V1 command tags. Each constant is documented at:
@@ -0,0 +1,134 @@
+package powerbankFleet
+
+import (
+	"fmt"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// InventoryEventKind is what changed in a slot between two check snapshots.
+type InventoryEventKind int
+
+const (
+	BankInserted InventoryEventKind = iota + 1 // an empty slot now holds a bank
+	BankRemoved                                // a bank left its slot
+	BankReplaced                               // the slot holds a different bank
+	StateChanged                               // same bank, different hole state
+	SOCCrossed                                 // same bank, SOC crossed a Differ threshold
+)
+
+func (k InventoryEventKind) String() string {
+	switch k {
+	case BankInserted:
+		return "bank inserted"
+	case BankRemoved:
+		return "bank removed"
+	case BankReplaced:
+		return "bank replaced"
+	case StateChanged:
+		return "state changed"
+	case SOCCrossed:
+		return "SOC crossed"
+	default:
+		return fmt.Sprintf("InventoryEventKind(%d)", int(k))
+	}
+}
+
+// InventoryEvent is one change to one slot. Previous is the hole as the older snapshot
+// reported it, Current as the newer one; for SOCCrossed, Threshold is the level crossed.
+type InventoryEvent struct {
+	Kind      InventoryEventKind
+	Previous  powerbankModels.Hole
+	Current   powerbankModels.Hole
+	Threshold int
+}
+
+// HoleIndex returns the slot the event is about.
+func (e InventoryEvent) HoleIndex() int {
+	return e.Current.HoleIndex
+}
+
+func (e InventoryEvent) String() string {
+	switch e.Kind {
+	case BankInserted:
+		return fmt.Sprintf("hole %d: %s inserted", e.HoleIndex(), e.Current.PowerbankSN)
+	case BankRemoved:
+		return fmt.Sprintf("hole %d: %s removed", e.HoleIndex(), e.Previous.PowerbankSN)
+	case BankReplaced:
+		return fmt.Sprintf("hole %d: %s replaced by %s", e.HoleIndex(), e.Previous.PowerbankSN, e.Current.PowerbankSN)
+	case StateChanged:
+		return fmt.Sprintf("hole %d: %s state %q -> %q", e.HoleIndex(), e.Current.PowerbankSN, e.Previous.GetStateDescription(), e.Current.GetStateDescription())
+	case SOCCrossed:
+		return fmt.Sprintf("hole %d: %s SOC %d%% -> %d%% crossed %d%%", e.HoleIndex(), e.Current.PowerbankSN, e.Previous.SOC, e.Current.SOC, e.Threshold)
+	default:
+		return fmt.Sprintf("hole %d: %v", e.HoleIndex(), e.Kind)
+	}
+}
+
+// Differ compares consecutive check snapshots of one cabinet. The zero value reports
+// every kind of event but SOCCrossed.
+type Differ struct {
+	// SOCThresholds are battery levels (percent) whose crossing, up or down, is
+	// reported, e.g. {20, 80} for "low" and "ready to rent".
+	SOCThresholds []int
+}
+
+// Diff compares next with prev hole by hole, matching holes by number, and returns
+// the changes in next's hole order. Holes missing from either snapshot are skipped;
+// a nil prev yields no events.
+func (d Differ) Diff(prev, next *powerbankModels.PowerBankCheckResponse) []InventoryEvent {
+	if prev == nil || next == nil {
+		return nil
+	}
+	before := make(map[int]powerbankModels.Hole)
+	for _, cb := range prev.ControlBoards {
+		for _, h := range cb.Holes {
+			before[h.HoleIndex] = h
+		}
+	}
+
+	var events []InventoryEvent
+	for _, cb := range next.ControlBoards {
+		for _, cur := range cb.Holes {
+			old, ok := before[cur.HoleIndex]
+			if !ok {
+				continue
+			}
+			events = d.diffHole(events, old, cur)
+		}
+	}
+	return events
+}
+
+func (d Differ) diffHole(events []InventoryEvent, old, cur powerbankModels.Hole) []InventoryEvent {
+	event := func(kind InventoryEventKind) InventoryEvent {
+		return InventoryEvent{Kind: kind, Previous: old, Current: cur}
+	}
+	switch wasEmpty, isEmpty := emptyHole(old), emptyHole(cur); {
+	case wasEmpty && isEmpty:
+		return events
+	case wasEmpty:
+		return append(events, event(BankInserted))
+	case isEmpty:
+		return append(events, event(BankRemoved))
+	case old.PowerbankSN != cur.PowerbankSN:
+		return append(events, event(BankReplaced))
+	}
+
+	if old.State != cur.State {
+		events = append(events, event(StateChanged))
+	}
+	for _, th := range d.SOCThresholds {
+		if (old.SOC < th) != (cur.SOC < th) {
+			e := event(SOCCrossed)
+			e.Threshold = th
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// emptyHole reports whether h holds no power bank.
+func emptyHole(h powerbankModels.Hole) bool {
+	return h.PowerbankSN == "" || h.PowerbankSN == emptySN
+}
@@ -0,0 +1,53 @@
+package powerbankFleet
+
+import (
+	"testing"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+func TestDiff(t *testing.T) {
+	prev := testCheck()
+	prev.ControlBoards[0].Holes[0] = powerbankModels.Hole{HoleIndex: 1, PowerbankSN: "85019121", State: 0x01, SOC: 85}
+	prev.ControlBoards[0].Holes[1] = powerbankModels.Hole{HoleIndex: 2, PowerbankSN: "85000001", State: 0x01, SOC: 50}
+	prev.ControlBoards[0].Holes[2] = powerbankModels.Hole{HoleIndex: 3, PowerbankSN: "85000002", State: 0x01, SOC: 30}
+
+	next := testCheck()
+	next.ControlBoards[0].Holes[0] = powerbankModels.Hole{HoleIndex: 1, PowerbankSN: "85019121", State: 0x04, SOC: 15}
+	next.ControlBoards[0].Holes[1] = powerbankModels.Hole{HoleIndex: 2, PowerbankSN: "0"}
+	next.ControlBoards[0].Holes[2] = powerbankModels.Hole{HoleIndex: 3, PowerbankSN: "85000003", State: 0x01, SOC: 30}
+	next.ControlBoards[0].Holes[3] = powerbankModels.Hole{HoleIndex: 4, PowerbankSN: "85000004", State: 0x01, SOC: 70}
+	next.ControlBoards[1].Holes[1].SOC = 95 // hole 6: no threshold crossed
+
+	events := Differ{SOCThresholds: []int{20, 80}}.Diff(prev, next)
+	want := []struct {
+		kind      InventoryEventKind
+		hole      int
+		threshold int
+	}{
+		{StateChanged, 1, 0},
+		{SOCCrossed, 1, 20},
+		{SOCCrossed, 1, 80},
+		{BankRemoved, 2, 0},
+		{BankReplaced, 3, 0},
+		{BankInserted, 4, 0},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events %v, want %d", events, len(want))
+	}
+	for i, w := range want {
+		if e := events[i]; e.Kind != w.kind || e.HoleIndex() != w.hole || e.Threshold != w.threshold {
+			t.Errorf("event %d: %v, want %v in hole %d", i, e, w.kind, w.hole)
+		}
+	}
+	if e := events[3]; e.Previous.PowerbankSN != "85000001" {
+		t.Errorf("removed bank: %q", e.Previous.PowerbankSN)
+	}
+
+	if events := (Differ{}).Diff(nil, next); events != nil {
+		t.Errorf("Diff(nil, next) = %v", events)
+	}
+	if events := (Differ{}).Diff(next, next); len(events) != 0 {
+		t.Errorf("Diff(next, next) = %v", events)
+	}
+}
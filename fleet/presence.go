@@ -0,0 +1,273 @@
+package powerbankFleet
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// Confirm limits, variables so tests can shorten them: one sweep's confirms share a
+// deadline of confirmTimeout, with at most confirmConcurrency of them in flight.
+var (
+	confirmTimeout     = 10 * time.Second
+	confirmConcurrency = 16
+)
+
+// forgetAfter is how long an offline cabinet is remembered, so device IDs from stray
+// topics do not pile up. A variable so tests can shorten it.
+var forgetAfter = 24 * time.Hour
+
+// Presence tracks which cabinets are online from the frames they send: a cabinet is
+// online from its first frame until it has been silent for OfflineAfter. It implements
+// powerbankModels.Handler; a host with its own Handler calls Heard for every frame.
+type Presence struct {
+	input powerbankModels.PresenceInput
+
+	mu      sync.Mutex
+	devices map[string]*presence
+	queue   []transition // callbacks not yet run, oldest first
+
+	wake   chan struct{} // a transition was queued
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type presence struct {
+	lastSeen time.Time
+	deadline time.Time // offline once past this, unless confirmed online
+	online   bool
+}
+
+type transition struct {
+	deviceID string
+	online   bool
+	at       time.Time // when it came online, or when it was last seen
+}
+
+// NewPresence starts a presence tracker; Close stops it.
+func NewPresence(input powerbankModels.PresenceInput) *Presence {
+	if input.OfflineAfter <= 0 {
+		input.OfflineAfter = constants.OFFLINE_AFTER
+	}
+	if input.CheckInterval <= 0 {
+		input.CheckInterval = input.OfflineAfter / 10
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Presence{
+		input:   input,
+		devices: make(map[string]*presence),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	p.wg.Add(2)
+	go p.run()
+	go p.dispatch()
+	return p
+}
+
+// Close stops looking for silent cabinets and waits for a running callback to return;
+// it must not be called from one. It does not fire OnOffline.
+func (p *Presence) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// Heard records a frame from deviceID received at at, bringing it online.
+func (p *Presence) Heard(deviceID string, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d, ok := p.devices[deviceID]
+	if !ok {
+		d = &presence{}
+		p.devices[deviceID] = d
+	}
+	if at.After(d.lastSeen) {
+		d.lastSeen = at
+		d.deadline = at.Add(p.input.OfflineAfter)
+	}
+	if !d.online {
+		d.online = true
+		p.notify(transition{deviceID, true, at})
+	}
+}
+
+// notify queues a transition for the dispatcher. Called with p.mu held, so the queue
+// follows the order of the transitions.
+func (p *Presence) notify(t transition) {
+	if (t.online && p.input.OnOnline == nil) || (!t.online && p.input.OnOffline == nil) {
+		return
+	}
+	p.queue = append(p.queue, t)
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// IsOnline reports whether deviceID is online. A cabinet never heard from is not.
+func (p *Presence) IsOnline(deviceID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d, ok := p.devices[deviceID]
+	return ok && d.online
+}
+
+// LastSeen returns when deviceID was last heard from, unless never or so long ago that
+// it was forgotten.
+func (p *Presence) LastSeen(deviceID string) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d, ok := p.devices[deviceID]
+	if !ok {
+		return time.Time{}, false
+	}
+	return d.lastSeen, true
+}
+
+// Online lists the cabinets online now, sorted by device ID.
+func (p *Presence) Online() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ids []string
+	for id, d := range p.devices {
+		if d.online {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (p *Presence) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.input.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.sweep(now)
+		}
+	}
+}
+
+// dispatch runs the queued callbacks one at a time, in order, with no lock held, so
+// a callback may call back into the tracker.
+func (p *Presence) dispatch() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.wake:
+		}
+		p.mu.Lock()
+		queue := p.queue
+		p.queue = nil
+		p.mu.Unlock()
+		for _, t := range queue {
+			if t.online {
+				p.input.OnOnline(t.deviceID, t.at)
+			} else {
+				p.input.OnOffline(t.deviceID, t.at)
+			}
+		}
+	}
+}
+
+// sweep takes every online cabinet past its deadline offline, unless Confirm vouches
+// for it, and forgets the cabinets offline for longer than forgetAfter.
+func (p *Presence) sweep(now time.Time) {
+	p.mu.Lock()
+	var silent []string
+	for id, d := range p.devices {
+		switch {
+		case d.online && now.After(d.deadline):
+			silent = append(silent, id)
+		case !d.online && now.Sub(d.lastSeen) > forgetAfter:
+			delete(p.devices, id)
+		}
+	}
+	p.mu.Unlock()
+
+	confirmed := p.confirm(silent)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, id := range silent {
+		d := p.devices[id]
+		switch {
+		case !d.online || !now.After(d.deadline):
+			// heard from meanwhile
+		case confirmed[i]:
+			d.deadline = time.Now().Add(p.input.OfflineAfter)
+		default:
+			d.online = false
+			p.notify(transition{id, false, d.lastSeen})
+		}
+	}
+}
+
+// confirm asks PresenceInput.Confirm about every device in ids concurrently, under one
+// deadline, and reports which are still connected. An error counts as not connected.
+func (p *Presence) confirm(ids []string) []bool {
+	confirmed := make([]bool, len(ids))
+	if p.input.Confirm == nil || len(ids) == 0 {
+		return confirmed
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, confirmTimeout)
+	defer cancel()
+	limit := make(chan struct{}, confirmConcurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		limit <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-limit; wg.Done() }()
+			online, err := p.input.Confirm(ctx, id)
+			confirmed[i] = err == nil && online
+		}()
+	}
+	wg.Wait()
+	return confirmed
+}
+
+// Handler implementation: any frame, even one that fails to decode, is a sign of life.
+
+func (p *Presence) OnCheck(deviceID string, _ *powerbankModels.PowerBankCheckResponse) {
+	p.Heard(deviceID, time.Now())
+}
+
+func (p *Presence) OnPopupBySN(deviceID string, _ *powerbankModels.PowerBankPopupResponse) {
+	p.Heard(deviceID, time.Now())
+}
+
+func (p *Presence) OnPopupByHole(deviceID string, _ *powerbankModels.PowerBankPopupByHoleResponse) {
+	p.Heard(deviceID, time.Now())
+}
+
+func (p *Presence) OnReturn(deviceID string, _ *powerbankModels.PowerBankReturnResponse) {
+	p.Heard(deviceID, time.Now())
+}
+
+func (p *Presence) OnReturnFix(deviceID string, _ *powerbankModels.PowerBankReturnFixResponse) {
+	p.Heard(deviceID, time.Now())
+}
+
+func (p *Presence) OnHeartbeat(deviceID string, _ *powerbankModels.PowerBankHealthCheckResponse, receivedAt time.Time) {
+	p.Heard(deviceID, receivedAt)
+}
+
+func (p *Presence) OnParseError(deviceID, _ string, _ []byte, _ error) {
+	if deviceID != "" {
+		p.Heard(deviceID, time.Now())
+	}
+}
+
+var _ powerbankModels.Handler = (*Presence)(nil)
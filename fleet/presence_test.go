@@ -0,0 +1,176 @@
+package powerbankFleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+type presenceEvent struct {
+	deviceID string
+	online   bool
+}
+
+func newTestPresence(t *testing.T, confirm func(context.Context, string) (bool, error)) (*Presence, chan presenceEvent) {
+	t.Helper()
+	events := make(chan presenceEvent, 16)
+	p := NewPresence(powerbankModels.PresenceInput{
+		OfflineAfter:  50 * time.Millisecond,
+		CheckInterval: 5 * time.Millisecond,
+		OnOnline:      func(id string, _ time.Time) { events <- presenceEvent{id, true} },
+		OnOffline:     func(id string, _ time.Time) { events <- presenceEvent{id, false} },
+		Confirm:       confirm,
+	})
+	t.Cleanup(p.Close)
+	return p, events
+}
+
+func expectTransition(t *testing.T, events chan presenceEvent, want presenceEvent) {
+	t.Helper()
+	select {
+	case got := <-events:
+		if got != want {
+			t.Fatalf("presenceEvent %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no presenceEvent, want %+v", want)
+	}
+}
+
+func TestPresence(t *testing.T) {
+	p, events := newTestPresence(t, nil)
+	if p.IsOnline(testDeviceID) {
+		t.Fatal("online before any frame")
+	}
+
+	p.OnHeartbeat(testDeviceID, &powerbankModels.PowerBankHealthCheckResponse{}, time.Now())
+	expectTransition(t, events, presenceEvent{testDeviceID, true})
+	// Frames within the window keep it online without another presenceEvent.
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		p.OnReturn(testDeviceID, &powerbankModels.PowerBankReturnResponse{})
+	}
+	if !p.IsOnline(testDeviceID) || len(events) != 0 {
+		t.Fatalf("while talking: online %v, %d transitions", p.IsOnline(testDeviceID), len(events))
+	}
+	if ids := p.Online(); len(ids) != 1 || ids[0] != testDeviceID {
+		t.Errorf("Online() = %v", ids)
+	}
+
+	expectTransition(t, events, presenceEvent{testDeviceID, false})
+	if p.IsOnline(testDeviceID) {
+		t.Error("online after going silent")
+	}
+	if at, ok := p.LastSeen(testDeviceID); !ok || time.Since(at) < 50*time.Millisecond {
+		t.Errorf("LastSeen = %v, %v", at, ok)
+	}
+
+	p.OnParseError(testDeviceID, "/powerbank/"+testDeviceID+"/user/update", nil, errors.New("garbled"))
+	expectTransition(t, events, presenceEvent{testDeviceID, true})
+}
+
+func TestPresenceConfirm(t *testing.T) {
+	var connected atomic.Bool
+	connected.Store(true)
+	var asked atomic.Int32
+	p, events := newTestPresence(t, func(_ context.Context, deviceID string) (bool, error) {
+		asked.Add(1)
+		if deviceID != testDeviceID {
+			return false, errors.New("unknown device")
+		}
+		return connected.Load(), nil
+	})
+
+	p.Heard(testDeviceID, time.Now())
+	expectTransition(t, events, presenceEvent{testDeviceID, true})
+	time.Sleep(150 * time.Millisecond)
+	if !p.IsOnline(testDeviceID) || asked.Load() == 0 {
+		t.Fatalf("silent but connected: online %v after %d confirms", p.IsOnline(testDeviceID), asked.Load())
+	}
+	connected.Store(false)
+	expectTransition(t, events, presenceEvent{testDeviceID, false})
+
+	// A failing confirm leaves it to the heartbeats.
+	p.Heard("864601068400000", time.Now())
+	expectTransition(t, events, presenceEvent{"864601068400000", true})
+	expectTransition(t, events, presenceEvent{"864601068400000", false})
+}
+
+// TestPresenceCallbacksMayReenter calls back into the tracker from the callbacks.
+func TestPresenceCallbacksMayReenter(t *testing.T) {
+	events := make(chan presenceEvent, 16)
+	var p *Presence
+	p = NewPresence(powerbankModels.PresenceInput{
+		OfflineAfter:  50 * time.Millisecond,
+		CheckInterval: 5 * time.Millisecond,
+		OnOnline: func(id string, at time.Time) {
+			p.Heard(id, at) // e.g. replaying a frame
+			events <- presenceEvent{id, p.IsOnline(id)}
+		},
+		OnOffline: func(id string, _ time.Time) {
+			if id == testDeviceID {
+				p.Heard("864601068400000", time.Now())
+			}
+			events <- presenceEvent{id, p.IsOnline(id)}
+		},
+	})
+	defer p.Close()
+
+	p.Heard(testDeviceID, time.Now())
+	expectTransition(t, events, presenceEvent{testDeviceID, true})
+	expectTransition(t, events, presenceEvent{testDeviceID, false})
+	expectTransition(t, events, presenceEvent{"864601068400000", true})
+}
+
+// TestPresenceConfirmsConcurrently stalls every confirm until the shared deadline: the
+// whole fleet still goes offline in about one confirmTimeout, not one per cabinet.
+func TestPresenceConfirmsConcurrently(t *testing.T) {
+	defer func(d time.Duration) { confirmTimeout = d }(confirmTimeout)
+	confirmTimeout = 200 * time.Millisecond
+
+	p, events := newTestPresence(t, func(ctx context.Context, _ string) (bool, error) {
+		<-ctx.Done()
+		return false, ctx.Err()
+	})
+	const cabinets = 10
+	for i := 0; i < cabinets; i++ {
+		p.Heard(fmt.Sprint(864601068400000+i), time.Now())
+	}
+	for i := 0; i < cabinets; i++ {
+		<-events
+	}
+	start := time.Now()
+	for i := 0; i < cabinets; i++ {
+		select {
+		case e := <-events:
+			if e.online {
+				t.Fatalf("unexpected %+v", e)
+			}
+		case <-time.After(5 * confirmTimeout):
+			t.Fatalf("%d of %d cabinets offline after %v", i, cabinets, time.Since(start))
+		}
+	}
+}
+
+func TestPresenceForgetsLongOfflineCabinets(t *testing.T) {
+	defer func(d time.Duration) { forgetAfter = d }(forgetAfter)
+	forgetAfter = 100 * time.Millisecond
+
+	p, events := newTestPresence(t, nil)
+	p.Heard(testDeviceID, time.Now())
+	expectTransition(t, events, presenceEvent{testDeviceID, true})
+	expectTransition(t, events, presenceEvent{testDeviceID, false})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := p.LastSeen(testDeviceID); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("offline cabinet never forgotten")
+		}
+	}
+}
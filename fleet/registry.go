@@ -0,0 +1,239 @@
+// Package powerbankFleet keeps an in-memory digital twin of every cabinet: the boards
+// and slots from the last 0x10 snapshot, kept current by the popup, return and
+// heartbeat frames that follow it.
+package powerbankFleet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// emptySN is the SN a check reports for an empty slot.
+const emptySN = "0"
+
+type (
+	// Cabinet is a snapshot of one cabinet's state. It is a copy; the Registry does not
+	// change it afterwards.
+	Cabinet struct {
+		DeviceID      string
+		Boards        []Board   // from the last check; empty until the first one
+		Signal        string    // last heartbeat signal payload, e.g. "CSQ:27;BP:0"
+		LastHeartbeat time.Time // zero before the first heartbeat
+		LastCheck     time.Time // last full 0x10 snapshot
+		LastSeen      time.Time // last frame of any kind
+	}
+
+	// Board is one control board with its firmware versions and slots.
+	Board struct {
+		ControlIndex int
+		Temperature  int
+		SoftVersion  int
+		HardVersion  int
+		Slots        []Slot
+	}
+
+	// Slot is a hole as last reported, by a check or a later popup or return frame.
+	Slot struct {
+		powerbankModels.Hole
+		UpdatedAt time.Time
+	}
+)
+
+// Empty reports whether the slot holds no power bank.
+func (s Slot) Empty() bool {
+	return emptyHole(s.Hole)
+}
+
+// Slot returns the slot with hole number hole, or false.
+func (c *Cabinet) Slot(hole int) (Slot, bool) {
+	for _, b := range c.Boards {
+		for _, s := range b.Slots {
+			if s.HoleIndex == hole {
+				return s, true
+			}
+		}
+	}
+	return Slot{}, false
+}
+
+// Registry is the set of cabinet twins. It implements powerbankModels.Handler, so it
+// can be passed as ServerInput.Handler directly; hosts with their own Handler call
+// Apply and ApplyHeartbeat from it instead. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	cabinets map[string]*Cabinet
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{cabinets: make(map[string]*Cabinet)}
+}
+
+// GetCabinet returns a snapshot of deviceID's cabinet, or false if no frame from it
+// has been applied.
+func (r *Registry) GetCabinet(deviceID string) (Cabinet, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.cabinets[deviceID]
+	if !ok {
+		return Cabinet{}, false
+	}
+	return c.clone(), true
+}
+
+// ListCabinets returns a snapshot of every cabinet, ordered by device ID.
+func (r *Registry) ListCabinets() []Cabinet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Cabinet, 0, len(r.cabinets))
+	for _, c := range r.cabinets {
+		list = append(list, c.clone())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DeviceID < list[j].DeviceID })
+	return list
+}
+
+// Apply updates deviceID's twin with a frame decoded by powerbankUtils.ParseResponse,
+// received at at. A check replaces every board and slot; a successful popup empties
+// its slot and a successful return fills it. Popup and return frames that arrive
+// before the cabinet's first check only advance LastSeen, as its slots are not yet
+// known.
+func (r *Registry) Apply(deviceID string, msg interface{}, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.cabinet(deviceID, at)
+
+	switch m := msg.(type) {
+	case *powerbankModels.PowerBankCheckResponse:
+		c.Boards = make([]Board, len(m.ControlBoards))
+		for i, cb := range m.ControlBoards {
+			b := Board{
+				ControlIndex: cb.ControlIndex,
+				Temperature:  cb.Temperature,
+				SoftVersion:  cb.SoftVersion,
+				HardVersion:  cb.HardVersion,
+				Slots:        make([]Slot, len(cb.Holes)),
+			}
+			for j, h := range cb.Holes {
+				b.Slots[j] = Slot{Hole: h, UpdatedAt: at}
+			}
+			c.Boards[i] = b
+		}
+		c.LastCheck = at
+	case *powerbankModels.PowerBankPopupResponse:
+		if m.State == powerbankModels.PopupSuccess {
+			c.update(m.HoleIndex, at, emptied)
+		}
+	case *powerbankModels.PowerBankPopupByHoleResponse:
+		if m.State == powerbankModels.PopupSuccess {
+			c.update(m.HoleIndex, at, emptied)
+		}
+	case *powerbankModels.PowerBankReturnResponse:
+		if m.State == powerbankModels.ReturnSuccess {
+			c.update(m.HoleIndex, at, func(h *powerbankModels.Hole) {
+				emptied(h)
+				h.State = 0x01 // power bank is normal
+				h.Area = m.Area
+				h.PowerbankSN = m.PowerbankSN
+				h.SOC = m.SOC
+				h.SoftVersion = m.SoftVersion
+			})
+		}
+	case *powerbankModels.PowerBankReturnFixResponse:
+		if m.State == powerbankModels.ReturnSuccess {
+			c.update(m.HoleIndex, at, func(h *powerbankModels.Hole) {
+				h.State = 0x01
+				h.Area = m.Area
+				h.PowerbankSN = m.PowerbankSN
+				h.SOC = m.SOC
+				h.Temperature = m.Temperature
+				h.ChargeVolt = m.ChargeVolt
+				h.ChargeCurr = m.ChargeCurr
+				h.SoftVersion = m.SoftVersion
+			})
+		}
+	}
+}
+
+// ApplyHeartbeat records a 0x7A heartbeat from deviceID received at at.
+func (r *Registry) ApplyHeartbeat(deviceID string, msg *powerbankModels.PowerBankHealthCheckResponse, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.cabinet(deviceID, at)
+	c.Signal = msg.Signal
+	c.LastHeartbeat = at
+}
+
+// cabinet returns deviceID's twin, creating it, and marks it seen at at.
+func (r *Registry) cabinet(deviceID string, at time.Time) *Cabinet {
+	c, ok := r.cabinets[deviceID]
+	if !ok {
+		c = &Cabinet{DeviceID: deviceID}
+		r.cabinets[deviceID] = c
+	}
+	if at.After(c.LastSeen) {
+		c.LastSeen = at
+	}
+	return c
+}
+
+// update applies fn to the slot with hole number hole, if known.
+func (c *Cabinet) update(hole int, at time.Time, fn func(*powerbankModels.Hole)) {
+	for b := range c.Boards {
+		for s := range c.Boards[b].Slots {
+			if slot := &c.Boards[b].Slots[s]; slot.HoleIndex == hole {
+				fn(&slot.Hole)
+				slot.UpdatedAt = at
+				return
+			}
+		}
+	}
+}
+
+// emptied clears the power bank from h, keeping the slot's own fields.
+func emptied(h *powerbankModels.Hole) {
+	*h = powerbankModels.Hole{HoleIndex: h.HoleIndex, Area: h.Area, Sensor: h.Sensor, PowerbankSN: emptySN}
+}
+
+func (c *Cabinet) clone() Cabinet {
+	out := *c
+	out.Boards = make([]Board, len(c.Boards))
+	for i, b := range c.Boards {
+		b.Slots = append([]Slot(nil), b.Slots...)
+		out.Boards[i] = b
+	}
+	return out
+}
+
+// Handler implementation: every frame is applied as received now.
+
+func (r *Registry) OnCheck(deviceID string, msg *powerbankModels.PowerBankCheckResponse) {
+	r.Apply(deviceID, msg, time.Now())
+}
+
+func (r *Registry) OnPopupBySN(deviceID string, msg *powerbankModels.PowerBankPopupResponse) {
+	r.Apply(deviceID, msg, time.Now())
+}
+
+func (r *Registry) OnPopupByHole(deviceID string, msg *powerbankModels.PowerBankPopupByHoleResponse) {
+	r.Apply(deviceID, msg, time.Now())
+}
+
+func (r *Registry) OnReturn(deviceID string, msg *powerbankModels.PowerBankReturnResponse) {
+	r.Apply(deviceID, msg, time.Now())
+}
+
+func (r *Registry) OnReturnFix(deviceID string, msg *powerbankModels.PowerBankReturnFixResponse) {
+	r.Apply(deviceID, msg, time.Now())
+}
+
+func (r *Registry) OnHeartbeat(deviceID string, msg *powerbankModels.PowerBankHealthCheckResponse, receivedAt time.Time) {
+	r.ApplyHeartbeat(deviceID, msg, receivedAt)
+}
+
+func (r *Registry) OnParseError(string, string, []byte, error) {}
+
+var _ powerbankModels.Handler = (*Registry)(nil)
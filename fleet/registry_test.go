@@ -0,0 +1,122 @@
+package powerbankFleet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankUtils "github.com/techpartners-asia/powerbank/utils"
+)
+
+const testDeviceID = "864601068412899"
+
+// apply round-trips res through the wire format, as the SDK would deliver it.
+func apply(t *testing.T, r *Registry, res interface{}, at time.Time) {
+	t.Helper()
+	frame, err := powerbankUtils.EncodeResponse(res)
+	if err != nil {
+		t.Fatalf("encode %T: %v", res, err)
+	}
+	_, msg, err := powerbankUtils.ParseResponse(frame)
+	if err != nil {
+		t.Fatalf("parse %T: %v", res, err)
+	}
+	r.Apply(testDeviceID, msg, at)
+}
+
+func testCheck() *powerbankModels.PowerBankCheckResponse {
+	board := func(index, firstHole int) powerbankModels.ControlBoard {
+		cb := powerbankModels.ControlBoard{ControlIndex: index, SoftVersion: 7, HardVersion: 2}
+		for h := firstHole; h < firstHole+4; h++ {
+			cb.Holes = append(cb.Holes, powerbankModels.Hole{HoleIndex: h, PowerbankSN: "0"})
+		}
+		return cb
+	}
+	check := &powerbankModels.PowerBankCheckResponse{ControlBoards: []powerbankModels.ControlBoard{board(1, 1), board(2, 5)}}
+	check.ControlBoards[1].Holes[1] = powerbankModels.Hole{HoleIndex: 6, PowerbankSN: "85021618", State: 0x01, SOC: 90}
+	return check
+}
+
+func TestRegistryFollowsFrames(t *testing.T) {
+	r := NewRegistry()
+	t0 := time.Now()
+
+	// Frames before the first check only mark the cabinet seen.
+	apply(t, r, &powerbankModels.PowerBankReturnResponse{ControlIndex: 1, HoleIndex: 2, PowerbankSN: "85019121", State: powerbankModels.ReturnSuccess, SOC: 40}, t0)
+	if c, ok := r.GetCabinet(testDeviceID); !ok || len(c.Boards) != 0 || !c.LastSeen.Equal(t0) {
+		t.Fatalf("before check: %+v, %v", c, ok)
+	}
+
+	apply(t, r, testCheck(), t0.Add(time.Second))
+	c, _ := r.GetCabinet(testDeviceID)
+	if len(c.Boards) != 2 || c.Boards[1].SoftVersion != 7 || !c.LastCheck.Equal(t0.Add(time.Second)) {
+		t.Fatalf("after check: %+v", c)
+	}
+	if s, ok := c.Slot(6); !ok || s.PowerbankSN != "85021618" || s.SOC != 90 || s.Empty() {
+		t.Fatalf("slot 6 after check: %+v", s)
+	}
+
+	apply(t, r, &powerbankModels.PowerBankPopupResponse{HoleIndex: 6, PowerbankSN: "85021618", State: powerbankModels.PopupSuccess}, t0.Add(2*time.Second))
+	apply(t, r, &powerbankModels.PowerBankReturnResponse{ControlIndex: 1, HoleIndex: 2, PowerbankSN: "85019121", State: powerbankModels.ReturnSuccess, SOC: 40, SoftVersion: 3}, t0.Add(3*time.Second))
+	apply(t, r, &powerbankModels.PowerBankPopupByHoleResponse{ControlIndex: 1, HoleIndex: 3, State: 0x00}, t0.Add(4*time.Second))
+	r.ApplyHeartbeat(testDeviceID, &powerbankModels.PowerBankHealthCheckResponse{Signal: "CSQ:27;BP:0"}, t0.Add(5*time.Second))
+
+	c, _ = r.GetCabinet(testDeviceID)
+	if s, _ := c.Slot(6); !s.Empty() || !s.UpdatedAt.Equal(t0.Add(2*time.Second)) {
+		t.Errorf("slot 6 after popup: %+v", s)
+	}
+	if s, _ := c.Slot(2); s.PowerbankSN != "85019121" || s.SOC != 40 || s.SoftVersion != 3 || s.State != 0x01 {
+		t.Errorf("slot 2 after return: %+v", s)
+	}
+	if s, _ := c.Slot(3); !s.UpdatedAt.Equal(t0.Add(time.Second)) {
+		t.Errorf("slot 3 changed by a failed popup: %+v", s)
+	}
+	if c.Signal != "CSQ:27;BP:0" || !c.LastHeartbeat.Equal(t0.Add(5*time.Second)) || !c.LastSeen.Equal(t0.Add(5*time.Second)) {
+		t.Errorf("heartbeat: %+v", c)
+	}
+}
+
+func TestRegistrySnapshotsAreCopies(t *testing.T) {
+	r := NewRegistry()
+	r.OnCheck(testDeviceID, testCheck())
+	r.OnCheck("864601068400000", testCheck())
+
+	c, _ := r.GetCabinet(testDeviceID)
+	c.Boards[1].Slots[1].PowerbankSN = "tampered"
+	if again, _ := r.GetCabinet(testDeviceID); again.Boards[1].Slots[1].PowerbankSN != "85021618" {
+		t.Error("changing a snapshot changed the registry")
+	}
+	if list := r.ListCabinets(); len(list) != 2 || list[0].DeviceID != "864601068400000" {
+		t.Errorf("ListCabinets: %+v", list)
+	}
+	if _, ok := r.GetCabinet("unknown"); ok {
+		t.Error("GetCabinet(unknown): found")
+	}
+}
+
+// TestRegistryConcurrentAccess is meant for -race: readers run while frames apply.
+func TestRegistryConcurrentAccess(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.OnCheck(testDeviceID, testCheck())
+				r.OnPopupBySN(testDeviceID, &powerbankModels.PowerBankPopupResponse{HoleIndex: 6, State: powerbankModels.PopupSuccess})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if c, ok := r.GetCabinet(testDeviceID); ok {
+					c.Slot(6)
+				}
+				r.ListCabinets()
+			}
+		}()
+	}
+	wg.Wait()
+}
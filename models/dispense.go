@@ -0,0 +1,46 @@
+package powerbankModels
+
+import (
+	"time"
+
+	"github.com/techpartners-asia/powerbank/constants"
+)
+
+// DispenseOutcome is what ApiService.Dispense established about the bank.
+type DispenseOutcome int
+
+const (
+	DispenseUnknown     DispenseOutcome = iota // neither the cabinet's reply nor a check settled it
+	DispenseEjected                            // the bank left its slot
+	DispenseStillInSlot                        // a check found the bank still in its slot
+)
+
+func (o DispenseOutcome) String() string {
+	switch o {
+	case DispenseEjected:
+		return "ejected"
+	case DispenseStillInSlot:
+		return "still in slot"
+	default:
+		return "unknown"
+	}
+}
+
+type (
+	// DispenseResult is the outcome of ApiService.Dispense with every command it sent.
+	DispenseResult struct {
+		Outcome  DispenseOutcome
+		Hole     int               // slot the bank left or sits in; 0 when not known
+		Attempts []DispenseAttempt // in order
+	}
+
+	// DispenseAttempt is one popup_sn or check that Dispense sent, or tried to, and its
+	// reply.
+	DispenseAttempt struct {
+		PublishType constants.PUBLISH_TYPE
+		Timestamp   string      // the popup_sn freshness timestamp; empty for check
+		SentAt      time.Time   // zero when it could not be published
+		Reply       interface{} // *PowerBankPopupResponse or *PowerBankCheckResponse; nil without one
+		Err         error       // why there is no reply: not published, or none in time
+	}
+)
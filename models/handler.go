@@ -0,0 +1,32 @@
+package powerbankModels
+
+import "time"
+
+type (
+	// Handler receives decoded cabinet frames, one method per frame type, so hosts need
+	// no type switch or assertion. deviceID is the cabinet's EMQX Client ID (IMEI) taken
+	// from the topic. Methods run in the MQTT receive goroutine; return promptly.
+	// Embed NopHandler to implement only the events you need.
+	Handler interface {
+		OnCheck(deviceID string, msg *PowerBankCheckResponse)             // 0x10, answer to check and upload_all
+		OnPopupBySN(deviceID string, msg *PowerBankPopupResponse)         // 0x31, answer to popup_sn
+		OnPopupByHole(deviceID string, msg *PowerBankPopupByHoleResponse) // 0x21, answer to popup
+		OnReturn(deviceID string, msg *PowerBankReturnResponse)           // 0x40, bank returned
+		OnReturnFix(deviceID string, msg *PowerBankReturnFixResponse)     // 0x28, return-fix report
+		// OnHeartbeat receives every 0x7A frame with the time the SDK received it.
+		OnHeartbeat(deviceID string, msg *PowerBankHealthCheckResponse, receivedAt time.Time)
+		// OnParseError receives a frame that failed to decode, with its topic and raw bytes.
+		OnParseError(deviceID, topic string, payload []byte, err error)
+	}
+
+	// NopHandler implements Handler by ignoring every event.
+	NopHandler struct{}
+)
+
+func (NopHandler) OnCheck(string, *PowerBankCheckResponse)                      {}
+func (NopHandler) OnPopupBySN(string, *PowerBankPopupResponse)                  {}
+func (NopHandler) OnPopupByHole(string, *PowerBankPopupByHoleResponse)          {}
+func (NopHandler) OnReturn(string, *PowerBankReturnResponse)                    {}
+func (NopHandler) OnReturnFix(string, *PowerBankReturnFixResponse)              {}
+func (NopHandler) OnHeartbeat(string, *PowerBankHealthCheckResponse, time.Time) {}
+func (NopHandler) OnParseError(string, string, []byte, error)                   {}
@@ -0,0 +1,44 @@
+package powerbankModels
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// IdempotencyStore records dispenses (popup_sn, popup) by PublishInput.IdempotencyKey,
+	// so a retried dispense is refused instead of ejecting a second bank. The SDK
+	// reserves a key before publishing, then completes it on a successful 0x31/0x21 reply
+	// or releases it on a failed reply or a failed publish; with no reply the key lapses
+	// with its ttl. Implementations must be safe for concurrent use; back one with Redis
+	// or SQL to share keys between processes.
+	IdempotencyStore interface {
+		// Reserve claims key as in flight for at most ttl. When the key is already in
+		// flight or completed it returns false and the key's state. It must not drop
+		// another live reservation to make room.
+		Reserve(ctx context.Context, key string, ttl time.Duration) (IdempotencyState, bool, error)
+		// Complete marks key as dispensed; it stays refused.
+		Complete(ctx context.Context, key string) error
+		// Release forgets key so the dispense may be retried.
+		Release(ctx context.Context, key string) error
+	}
+
+	// IdempotencyState is the state of a reserved idempotency key.
+	IdempotencyState int
+)
+
+const (
+	IdempotencyInFlight  IdempotencyState = iota + 1 // published, reply pending
+	IdempotencyCompleted                             // the cabinet reported the bank ejected
+)
+
+func (s IdempotencyState) String() string {
+	switch s {
+	case IdempotencyInFlight:
+		return "in flight"
+	case IdempotencyCompleted:
+		return "completed"
+	default:
+		return "unknown"
+	}
+}
@@ -1,6 +1,9 @@
 package powerbankModels
 
 import (
+	"context"
+	"time"
+
 	"github.com/techpartners-asia/powerbank/constants"
 )
 
@@ -10,8 +13,111 @@ type (
 		Port              string
 		Username          string
 		Password          string
-		Debug             bool // when true, emits MQTT debug/error logs and verbose traces
+		Scheme            string    // broker URL scheme: "tcp" (default), "ssl"/"mqtts" for TLS, "ws"/"wss" for WebSocket
+		Path              string    // WebSocket URL path for ws/wss; defaults to "/mqtt"
+		TLS               *TLSInput // TLS settings for ssl/mqtts/wss; setting it without Scheme selects "ssl"
+		Debug             bool      // when true, emits MQTT debug/error logs and verbose traces
+		ProtocolVersion   uint      // MQTT protocol level: 4 for 3.1.1 (default) or 5 for MQTT 5
+		LenientChecksum   bool      // when true, accepts frames whose check code does not verify (known-bad firmware)
+		Handler           Handler   // typed event per decoded frame; set it or the Callback* functions, not both
 		CallbackSubscribe func(typ constants.PUBLISH_TYPE, clientID string, msg interface{})
+		// CallbackHeartbeat receives every 0x7A frame from /powerbank/+/user/heart along
+		// with the time the SDK received it. Optional; heartbeats are dropped when nil.
+		CallbackHeartbeat func(deviceID string, msg *PowerBankHealthCheckResponse, receivedAt time.Time)
+		// CallbackParseError receives every frame the SDK could not decode — corrupted,
+		// truncated, or an unknown command byte (powerbankUtils.ErrUnknownCommand) — with
+		// its topic and raw bytes. deviceID is empty when the topic carries none. Optional.
+		CallbackParseError func(deviceID, topic string, payload []byte, err error)
+		// Connection lifecycle hooks, all optional. OnConnected runs after every connect
+		// and reconnect once the broker has acknowledged every subscription;
+		// OnConnectionLost when the connection drops unexpectedly; OnReconnecting before
+		// each reconnect attempt. OnSubscribeError reports subscriptions still refused or
+		// unanswered after several attempts (wrapping powerbankSdk.ErrSubscriptionRejected
+		// on a SUBACK failure); the SDK keeps retrying with backoff until they succeed.
+		OnConnected      func()
+		OnConnectionLost func(err error)
+		OnReconnecting   func()
+		OnSubscribeError func(err error)
+		// SharedGroup, when set, subscribes to the update and heart topics as the MQTT
+		// shared subscription $share/{SharedGroup}/..., so the broker delivers each frame
+		// to one service of the group instead of all — for horizontally scaled replicas.
+		// PublishAndWait is unavailable in this mode (the reply may reach another replica).
+		SharedGroup string
+		// Topics lists the topic layouts to serve, e.g. one per operator prefix on a shared
+		// broker. Defaults to the single /powerbank/{device}/user/... layout.
+		Topics []TopicLayout
+		// OfflineAfter, when positive, enables the MQTT 3.1.1 offline guard: a cabinet
+		// silent (no frame on any topic) for longer counts as offline, and a popup to one
+		// offline for longer than the popup's TTL is refused with
+		// powerbankSdk.ErrDeviceOffline. constants.OFFLINE_AFTER, the 9m heartbeat plus
+		// slack, suits the protocol. Silence counts from our latest broker connection; a
+		// cabinet not heard from yet is let through. Ignored with SharedGroup (a replica
+		// sees only some of the frames).
+		OfflineAfter time.Duration
+		// IdempotencyStore keeps the dispenses published with an IdempotencyKey; defaults
+		// to an in-memory store of the latest 10000 keys. Share one store (Redis, SQL)
+		// between replicas so a retry on another replica is refused too. Keys need every
+		// reply, so they are refused with a SharedGroup.
+		IdempotencyStore IdempotencyStore
+	}
+
+	// TLSInput configures the TLS connection to the broker. All fields are optional:
+	// the zero value verifies the broker against the system roots.
+	TLSInput struct {
+		CAFile             string // PEM CA bundle to verify the broker with, instead of the system roots
+		CertFile           string // PEM client certificate, for mutual TLS (requires KeyFile)
+		KeyFile            string // PEM client private key, for mutual TLS (requires CertFile)
+		ServerName         string // name to verify the broker certificate against; defaults to Host
+		InsecureSkipVerify bool   // skips broker certificate verification — development only
+	}
+
+	// TopicLayout is one set of cabinet topic templates. Each has exactly one {device}
+	// placeholder filling a whole level, e.g. "/acme/powerbank/{device}/user/get"; the
+	// device ID of incoming frames is read from that level.
+	TopicLayout struct {
+		Name    string // selects the layout in PublishInput.Layout; required to be unique when set
+		Publish string // commands to the cabinet (user/get)
+		Update  string // cabinet responses and reports (user/update)
+		Heart   string // cabinet heartbeats (user/heart)
+	}
+
+	// SimulatorInput configures a simulated cabinet (package simulator). Holes are
+	// numbered 1..Boards*HolesPerBoard across boards, as on real hardware.
+	SimulatorInput struct {
+		Host              string
+		Port              string
+		Username          string
+		Password          string
+		DeviceID          string        // EMQX Client ID the fake cabinet connects as (IMEI)
+		Boards            int           // control boards; defaults to 1
+		HolesPerBoard     int           // holes per board (1-4, only 4 with several Boards); defaults to 4
+		HeartbeatInterval time.Duration // 0x7A period; defaults to 9m, negative disables
+		Signal            string        // heart signal payload; defaults to "CSQ:27;BP:0"
+		Debug             bool          // when true, logs every command and reply
+		Topics            TopicLayout   // topic templates the cabinet uses; zero value is the default layout
+	}
+
+	// PresenceInput configures a presence tracker (package fleet). All fields are optional.
+	PresenceInput struct {
+		// OfflineAfter is how long a cabinet may stay silent (no 0x7A heartbeat nor any
+		// other frame) before it counts as offline; defaults to constants.OFFLINE_AFTER,
+		// the 9m heartbeat plus slack.
+		OfflineAfter time.Duration
+		// CheckInterval is how often silent cabinets are looked for; defaults to
+		// OfflineAfter/10, so an offline cabinet is declared at most 10% late.
+		CheckInterval time.Duration
+		// OnOnline runs when a cabinet is heard from for the first time or after being
+		// offline; OnOffline when it goes offline, with the time it was last heard from.
+		// They run in order, one at a time, on a goroutine of their own; they may call
+		// back into the tracker, except Close.
+		OnOnline  func(deviceID string, at time.Time)
+		OnOffline func(deviceID string, lastSeen time.Time)
+		// Confirm, when set, is asked before a silent cabinet is declared offline, e.g.
+		// with the broker's view of its connection (UserService). While it answers true
+		// the cabinet stays online for another OfflineAfter; an error leaves the verdict
+		// to the heartbeats. The cabinets found silent together are confirmed
+		// concurrently, under one 10s deadline.
+		Confirm func(ctx context.Context, deviceID string) (online bool, err error)
 	}
 
 	UserInput struct {
@@ -21,6 +127,9 @@ type (
 		Password  string
 		ApiKey    string
 		ApiSecret string
+		// LenientStatus restores the legacy AddUser/GetUser behaviour: a non-2xx answer
+		// is decoded like a success instead of returning a *powerbankSdk.EMQXError.
+		LenientStatus bool
 	}
 )
 
@@ -32,5 +141,20 @@ type (
 		IO          string // optional main control board serial port for popup ("0" or "1", default "0")
 		Timestamp   string // optional Unix timestamp (seconds) — popup_sn / popup enhanced form
 		TTL         string // optional effective time in seconds — popup_sn / popup enhanced form
+		Layout      string // optional TopicLayout.Name to publish under; defaults to the layout the device was last heard on
+		// IdempotencyKey, e.g. the rental ID, makes a popup_sn/popup safe to retry: a second
+		// dispense with the same key is refused while the first is in flight and, once
+		// the cabinet reports the bank ejected, for good (ServerInput.IdempotencyStore).
+		// With no reply within 30s the outcome is unknown, and the key stays in flight,
+		// refused with powerbankSdk.ErrDispenseInFlight, for 10 minutes from the publish;
+		// check the slot before retrying under a new key.
+		// Not available with a ServerInput.SharedGroup (powerbankSdk.ErrSharedSubscription).
+		IdempotencyKey string
+		// MQTT 5 publish properties (ServerInput.ProtocolVersion 5 only), e.g. to
+		// correlate a command with the host request that issued it. Cabinets ignore them;
+		// they are for brokers, rules and bridges on the way.
+		UserProperties  map[string]string
+		ResponseTopic   string
+		CorrelationData []byte
 	}
 )
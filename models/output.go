@@ -375,6 +375,18 @@ func (healthCheck *PowerBankHealthCheckResponse) GetSignalBars() int {
 	}
 }
 
+// HoleWithSN returns the hole holding the power bank sn, or nil when none does.
+func (check *PowerBankCheckResponse) HoleWithSN(sn string) *Hole {
+	for b := range check.ControlBoards {
+		for h := range check.ControlBoards[b].Holes {
+			if hole := &check.ControlBoards[b].Holes[h]; hole.PowerbankSN == sn {
+				return hole
+			}
+		}
+	}
+	return nil
+}
+
 func (hole *Hole) GetStateDescription() string {
 	switch hole.State {
 	case 0x00:
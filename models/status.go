@@ -0,0 +1,22 @@
+package powerbankModels
+
+import "time"
+
+// ServiceStatus is a snapshot of an ApiService's broker connection, for readiness and
+// liveness probes.
+type ServiceStatus struct {
+	Connected   bool      // the MQTT connection is up
+	Subscribed  bool      // every update and heart subscription was acknowledged on the current connection
+	ConnectedAt time.Time // when the current (or last) connection was established
+	Reconnects  int       // connections re-established after a loss since NewServer
+	LastError   error     // why the connection was last lost, or nil
+	// SubscribeError is why the subscriptions on the current connection are not (yet)
+	// in place — a SUBACK failure, such as an ACL deny, or no SUBACK at all — while the
+	// SDK keeps retrying. Nil once Subscribed.
+	SubscribeError error
+}
+
+// Ready reports whether the service is connected and will receive cabinet frames.
+func (s ServiceStatus) Ready() bool {
+	return s.Connected && s.Subscribed
+}
@@ -0,0 +1,86 @@
+package powerbankMqttTest
+
+import (
+	"bytes"
+	"sync"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// aclHook accepts every client and allows every publish and subscribe except
+// subscriptions denied with Broker.DenySubscribe, which are answered with a SUBACK
+// failure (0x80 on MQTT 3.1.1), and publishes denied with Broker.DenyPublish.
+type aclHook struct {
+	mqtt.HookBase
+	mu          sync.Mutex
+	deny        map[string]int  // filter -> remaining denials; negative denies until allowed
+	denyPublish map[string]bool // topics clients may not publish to
+}
+
+func (h *aclHook) ID() string {
+	return "powerbank-acl"
+}
+
+func (h *aclHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{mqtt.OnConnectAuthenticate, mqtt.OnACLCheck}, []byte{b})
+}
+
+func (h *aclHook) OnConnectAuthenticate(*mqtt.Client, packets.Packet) bool {
+	return true
+}
+
+func (h *aclHook) OnACLCheck(_ *mqtt.Client, topic string, write bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if write {
+		return !h.denyPublish[topic]
+	}
+	n, ok := h.deny[topic]
+	if !ok {
+		return true
+	}
+	if n > 0 {
+		if n == 1 {
+			delete(h.deny, topic)
+		} else {
+			h.deny[topic] = n - 1
+		}
+	}
+	return false
+}
+
+// DenySubscribe makes the broker reject the next times subscriptions to filter, or
+// every one until AllowSubscribe when times <= 0 — the ACL-deny case a client must
+// notice rather than sit connected but deaf.
+func (b *Broker) DenySubscribe(filter string, times int) {
+	if times <= 0 {
+		times = -1
+	}
+	b.acl.mu.Lock()
+	defer b.acl.mu.Unlock()
+	b.acl.deny[filter] = times
+}
+
+// AllowSubscribe lifts a DenySubscribe on filter.
+func (b *Broker) AllowSubscribe(filter string) {
+	b.acl.mu.Lock()
+	defer b.acl.mu.Unlock()
+	delete(b.acl.deny, filter)
+}
+
+// DenyPublish makes the broker refuse client publishes to topic until AllowPublish:
+// QoS 0 messages are dropped, QoS 1 ones answered with PUBACK 0x87 Not authorized on
+// MQTT 5 (an MQTT 3.1.1 client is disconnected).
+func (b *Broker) DenyPublish(topic string) {
+	b.acl.mu.Lock()
+	defer b.acl.mu.Unlock()
+	b.acl.denyPublish[topic] = true
+}
+
+// AllowPublish lifts a DenyPublish on topic.
+func (b *Broker) AllowPublish(topic string) {
+	b.acl.mu.Lock()
+	defer b.acl.mu.Unlock()
+	delete(b.acl.denyPublish, topic)
+}
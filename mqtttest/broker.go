@@ -0,0 +1,166 @@
+// Package powerbankMqttTest runs an in-process MQTT broker for tests, so NewServer,
+// Publish and the subscription handlers (and the cabinet simulator) can be exercised
+// end to end with `go test` and no external broker.
+package powerbankMqttTest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// Broker is an in-process MQTT broker listening on a random loopback port. It accepts
+// any credentials and allows every publish and subscribe unless told otherwise with
+// DenySubscribe or DenyPublish. Each message of a shared subscription goes to any one
+// member of the group, unless HashSharedSubscriptions is set.
+type Broker struct {
+	server *mqtt.Server
+	host   string
+	port   string
+	acl    *aclHook
+	shared *sharedHook
+	subs   atomic.Int32 // last inline subscription identifier
+	close  sync.Once
+}
+
+// NewBroker starts a broker on 127.0.0.1 with an OS-assigned port. Close it when done.
+func NewBroker() (*Broker, error) {
+	return newBroker(listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"}))
+}
+
+// NewTLSBroker is NewBroker with a TLS listener using config; set config.ClientAuth to
+// require client certificates (mutual TLS).
+func NewTLSBroker(config *tls.Config) (*Broker, error) {
+	return newBroker(listeners.NewTCP(listeners.Config{ID: "tls", Address: "127.0.0.1:0", TLSConfig: config}))
+}
+
+// NewWebsocketBroker is NewBroker with an MQTT-over-WebSocket listener (ws://) that
+// accepts any request path.
+func NewWebsocketBroker() (*Broker, error) {
+	// The websocket listener cannot report an OS-assigned port, so reserve one first.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("broker: reserve port: %w", err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	b, err := newBroker(listeners.NewWebsocket(listeners.Config{ID: "ws", Address: addr}))
+	if err != nil {
+		return nil, err
+	}
+
+	// The HTTP server starts listening in the background; wait until it accepts.
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			return b, nil
+		}
+		if time.Now().After(deadline) {
+			b.Close()
+			return nil, fmt.Errorf("broker: websocket listener never came up: %w", err)
+		}
+	}
+}
+
+func newBroker(listener listeners.Listener) (*Broker, error) {
+	server := mqtt.New(&mqtt.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.DiscardHandler),
+	})
+	acl := &aclHook{deny: make(map[string]int), denyPublish: make(map[string]bool)}
+	if err := server.AddHook(acl, nil); err != nil {
+		return nil, fmt.Errorf("broker: add auth hook: %w", err)
+	}
+	shared := &sharedHook{}
+	if err := server.AddHook(shared, nil); err != nil {
+		return nil, fmt.Errorf("broker: add shared subscription hook: %w", err)
+	}
+
+	if err := server.AddListener(listener); err != nil {
+		return nil, fmt.Errorf("broker: listen: %w", err)
+	}
+	host, port, err := net.SplitHostPort(listener.Address())
+	if err != nil {
+		return nil, fmt.Errorf("broker: listen address: %w", err)
+	}
+
+	if err := server.Serve(); err != nil {
+		return nil, fmt.Errorf("broker: serve: %w", err)
+	}
+	return &Broker{server: server, host: host, port: port, acl: acl, shared: shared}, nil
+}
+
+// DisconnectClients drops every client connection, as a broker restart would; clients
+// that auto-reconnect come back.
+func (b *Broker) DisconnectClients() {
+	for _, cl := range b.server.Clients.GetAll() {
+		if !cl.Net.Inline {
+			cl.Stop(packets.ErrServerShuttingDown)
+		}
+	}
+}
+
+// Host returns the broker's host, for ServerInput.Host and friends.
+func (b *Broker) Host() string {
+	return b.host
+}
+
+// Port returns the broker's listening port, for ServerInput.Port and friends.
+func (b *Broker) Port() string {
+	return b.port
+}
+
+// Publish injects a message as if a client had published it, e.g. a raw cabinet frame.
+func (b *Broker) Publish(topic string, payload []byte) error {
+	return b.server.Publish(topic, payload, false, 0)
+}
+
+// Message is a publish seen by a Subscribe callback, with its MQTT 5 properties (zero
+// for messages from MQTT 3.1.1 clients).
+type Message struct {
+	Topic           string
+	Payload         []byte
+	QoS             byte
+	UserProperties  map[string]string
+	ResponseTopic   string
+	CorrelationData []byte
+	MessageExpiry   uint32 // seconds; 0 when unset
+}
+
+// Subscribe calls fn with every message published to a topic matching filter, e.g. to
+// assert on the commands and properties a service sends a cabinet. fn runs in the
+// broker's delivery goroutine.
+func (b *Broker) Subscribe(filter string, fn func(Message)) error {
+	return b.server.Subscribe(filter, int(b.subs.Add(1)), func(_ *mqtt.Client, _ packets.Subscription, pk packets.Packet) {
+		msg := Message{
+			Topic:           pk.TopicName,
+			Payload:         append([]byte(nil), pk.Payload...),
+			QoS:             pk.FixedHeader.Qos,
+			ResponseTopic:   pk.Properties.ResponseTopic,
+			CorrelationData: append([]byte(nil), pk.Properties.CorrelationData...),
+			MessageExpiry:   pk.Properties.MessageExpiryInterval,
+		}
+		if len(pk.Properties.User) > 0 {
+			msg.UserProperties = make(map[string]string, len(pk.Properties.User))
+			for _, p := range pk.Properties.User {
+				msg.UserProperties[p.Key] = p.Val
+			}
+		}
+		fn(msg)
+	})
+}
+
+// Close disconnects every client and stops the broker. It is safe to call more than
+// once, e.g. to simulate an outage in a test that also closes it on cleanup.
+func (b *Broker) Close() {
+	b.close.Do(func() { _ = b.server.Close() })
+}
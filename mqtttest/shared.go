@@ -0,0 +1,58 @@
+package powerbankMqttTest
+
+import (
+	"bytes"
+	"hash/fnv"
+	"sort"
+	"sync/atomic"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// sharedHook picks the member of each shared subscription group that gets a message.
+// By default the broker picks any member; with sticky set it hashes the publisher's
+// client ID, like EMQX's hash_clientid strategy, so one cabinet's frames always reach
+// the same member.
+type sharedHook struct {
+	mqtt.HookBase
+	sticky atomic.Bool
+}
+
+func (h *sharedHook) ID() string {
+	return "powerbank-shared"
+}
+
+func (h *sharedHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{mqtt.OnSelectSubscribers}, []byte{b})
+}
+
+func (h *sharedHook) OnSelectSubscribers(subs *mqtt.Subscribers, pk packets.Packet) *mqtt.Subscribers {
+	if !h.sticky.Load() {
+		return subs
+	}
+	sum := fnv.New32a()
+	_, _ = sum.Write([]byte(pk.Origin))
+	subs.SharedSelected = map[string]packets.Subscription{}
+	for _, group := range subs.Shared {
+		members := make([]string, 0, len(group))
+		for client := range group {
+			members = append(members, client)
+		}
+		sort.Strings(members)
+		client := members[sum.Sum32()%uint32(len(members))]
+		sub := group[client]
+		if selected, ok := subs.SharedSelected[client]; ok {
+			sub = selected.Merge(sub)
+		}
+		subs.SharedSelected[client] = sub
+	}
+	return subs
+}
+
+// HashSharedSubscriptions makes the broker deliver every message of a shared
+// subscription to the group member picked by hashing the publisher's client ID, as
+// EMQX does with shared_subscription_strategy = hash_clientid.
+func (b *Broker) HashSharedSubscriptions() {
+	b.shared.sticky.Store(true)
+}
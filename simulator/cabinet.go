@@ -0,0 +1,224 @@
+package powerbankSimulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankUtils "github.com/techpartners-asia/powerbank/utils"
+)
+
+// command is the JSON the SDK publishes to /powerbank/{id}/user/get.
+type command struct {
+	Cmd       constants.PUBLISH_TYPE `json:"cmd"`
+	Data      string                 `json:"data"`
+	IO        string                 `json:"io"`
+	Timestamp string                 `json:"timestamp"`
+	TTL       string                 `json:"ttl"`
+}
+
+// fault is a scripted misbehaviour for the next command of one type.
+type fault struct {
+	state int  // reply state byte; ignored when drop is set
+	eject bool // whether the bank still leaves its slot
+	drop  bool // act, but send no reply
+}
+
+// replyTopic names which of the cabinet's uplink topics a frame goes to; the
+// Simulator maps it to its configured layout.
+type replyTopic int
+
+const (
+	replyUpdate replyTopic = iota // /user/update
+	replyHeart                    // /user/heart
+)
+
+// reply is a frame the cabinet sends in answer to a command, and the topic it goes to.
+type reply struct {
+	topic replyTopic
+	frame []byte
+}
+
+// cabinet is the simulated hardware state and command logic, free of MQTT so it can be
+// driven directly in tests. Safe for concurrent use.
+type cabinet struct {
+	mu     sync.Mutex
+	boards []powerbankModels.ControlBoard
+	signal string
+	faults map[constants.PUBLISH_TYPE][]fault
+}
+
+func newCabinet(boards, holesPerBoard int, signal string) *cabinet {
+	c := &cabinet{signal: signal, faults: make(map[constants.PUBLISH_TYPE][]fault)}
+	for b := 1; b <= boards; b++ {
+		cb := powerbankModels.ControlBoard{
+			ControlIndex: b,
+			Undefined1:   0xFF,
+			Undefined2:   0xFF,
+			SoftVersion:  4,
+			HardVersion:  0x16,
+		}
+		for h := 1; h <= holesPerBoard; h++ {
+			cb.Holes = append(cb.Holes, emptyHole((b-1)*holesPerBoard+h))
+		}
+		c.boards = append(c.boards, cb)
+	}
+	return c
+}
+
+func emptyHole(index int) powerbankModels.Hole {
+	return powerbankModels.Hole{HoleIndex: index, PowerbankSN: "0", Sensor: 0x80}
+}
+
+// hole returns the slot with the given hole number and its board, or nil.
+func (c *cabinet) hole(index int) (*powerbankModels.ControlBoard, *powerbankModels.Hole) {
+	for b := range c.boards {
+		for h := range c.boards[b].Holes {
+			if c.boards[b].Holes[h].HoleIndex == index {
+				return &c.boards[b], &c.boards[b].Holes[h]
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (c *cabinet) holeBySN(sn string) (*powerbankModels.ControlBoard, *powerbankModels.Hole) {
+	for b := range c.boards {
+		for h := range c.boards[b].Holes {
+			if hole := &c.boards[b].Holes[h]; hole.State != 0 && hole.PowerbankSN == sn {
+				return &c.boards[b], hole
+			}
+		}
+	}
+	return nil, nil
+}
+
+// insert puts a charged, healthy bank in a slot and returns its board.
+func (c *cabinet) insert(index int, sn string, soc int) (*powerbankModels.ControlBoard, error) {
+	if _, err := strconv.ParseUint(sn, 10, 32); err != nil {
+		return nil, fmt.Errorf("powerbank SN %q: %w", sn, err)
+	}
+	cb, h := c.hole(index)
+	if h == nil {
+		return nil, fmt.Errorf("no hole %d", index)
+	}
+	*h = powerbankModels.Hole{
+		HoleIndex:     index,
+		State:         0x01,
+		PowerbankVolt: 4.2,
+		PowerbankSN:   sn,
+		SOC:           soc,
+		Temperature:   30,
+		ChargeVolt:    5.0,
+		SoftVersion:   13,
+	}
+	return cb, nil
+}
+
+func (c *cabinet) nextFault(typ constants.PUBLISH_TYPE) (fault, bool) {
+	q := c.faults[typ]
+	if len(q) == 0 {
+		return fault{}, false
+	}
+	c.faults[typ] = q[1:]
+	return q[0], true
+}
+
+func (c *cabinet) heart() ([]byte, error) {
+	return powerbankUtils.EncodeHealthCheckResponse(&powerbankModels.PowerBankHealthCheckResponse{
+		ControlIndex: 0x10,
+		Signal:       c.signal,
+	})
+}
+
+func (c *cabinet) snapshot() *powerbankModels.PowerBankCheckResponse {
+	res := &powerbankModels.PowerBankCheckResponse{Head: powerbankUtils.FrameHead, Cmd: 0x10}
+	for _, cb := range c.boards {
+		cb.Holes = append([]powerbankModels.Hole(nil), cb.Holes...)
+		res.ControlBoards = append(res.ControlBoards, cb)
+	}
+	return res
+}
+
+// handle executes one /user/get command and returns the cabinet's reply, or nil when
+// it sends none.
+func (c *cabinet) handle(payload []byte) (*reply, error) {
+	var cmd command
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return nil, fmt.Errorf("decode command %q: %w", payload, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch cmd.Cmd {
+	case constants.PUBLISH_TYPE_CHECK, constants.PUBLISH_TYPE_UPLOAD:
+		if f, ok := c.nextFault(cmd.Cmd); ok && f.drop {
+			return nil, nil
+		}
+		return update(powerbankUtils.EncodeCheckResponse(c.snapshot()))
+	case constants.PUBLISH_TYPE_POPUP:
+		res := &powerbankModels.PowerBankPopupResponse{PowerbankSN: cmd.Data, State: 0x01}
+		_, h := c.holeBySN(cmd.Data)
+		if h == nil {
+			res.State = 0xFC // target SN not found
+		} else {
+			res.HoleIndex = h.HoleIndex
+		}
+		f, faulted := c.nextFault(cmd.Cmd)
+		if h != nil && (!faulted || f.eject) {
+			*h = emptyHole(h.HoleIndex)
+		}
+		if faulted {
+			if f.drop {
+				return nil, nil
+			}
+			res.State = f.state
+		}
+		return update(powerbankUtils.EncodePopupPowerBankResponse(res))
+	case constants.PUBLISH_TYPE_POPUP_BY_HOLE:
+		index, err := strconv.Atoi(cmd.Data)
+		if err != nil {
+			return update(powerbankUtils.EncodePopupByHolePowerBankResponse(&powerbankModels.PowerBankPopupByHoleResponse{State: 0xFF}))
+		}
+		res := &powerbankModels.PowerBankPopupByHoleResponse{HoleIndex: index, State: 0x01}
+		cb, h := c.hole(index)
+		if h == nil || h.State == 0 {
+			res.State = 0x00 // nothing to pop
+		}
+		if cb != nil {
+			res.ControlIndex = cb.ControlIndex
+		}
+		f, faulted := c.nextFault(cmd.Cmd)
+		if res.State == 0x01 && (!faulted || f.eject) {
+			*h = emptyHole(index)
+		}
+		if faulted {
+			if f.drop {
+				return nil, nil
+			}
+			res.State = f.state
+		}
+		return update(powerbankUtils.EncodePopupByHolePowerBankResponse(res))
+	case constants.PUBLISH_TYPE_REBOOT:
+		// A rebooted cabinet comes back and announces itself with a heart frame.
+		frame, err := c.heart()
+		if err != nil {
+			return nil, err
+		}
+		return &reply{topic: replyHeart, frame: frame}, nil
+	default:
+		// load_ad and anything unknown: no reply.
+		return nil, nil
+	}
+}
+
+func update(frame []byte, err error) (*reply, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &reply{topic: replyUpdate, frame: frame}, nil
+}
@@ -0,0 +1,105 @@
+package powerbankSimulator
+
+import (
+	"testing"
+
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankUtils "github.com/techpartners-asia/powerbank/utils"
+)
+
+// handleAndParse runs one command through the cabinet and parses its /user/update reply.
+func handleAndParse(t *testing.T, c *cabinet, cmd string) interface{} {
+	t.Helper()
+	rep, err := c.handle([]byte(cmd))
+	if err != nil {
+		t.Fatalf("%s: %v", cmd, err)
+	}
+	if rep == nil {
+		return nil
+	}
+	if rep.topic != replyUpdate {
+		t.Fatalf("%s: reply on the heart topic, want update", cmd)
+	}
+	_, res, err := powerbankUtils.ParseResponse(rep.frame)
+	if err != nil {
+		t.Fatalf("%s: parse reply % X: %v", cmd, rep.frame, err)
+	}
+	return res
+}
+
+func TestCabinetPopupBySNEjects(t *testing.T) {
+	c := newCabinet(2, 4, defaultSignal)
+	if _, err := c.insert(6, "85021618", 90); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	check := handleAndParse(t, c, `{"cmd":"check"}`).(*powerbankModels.PowerBankCheckResponse)
+	if len(check.ControlBoards) != 2 || check.ControlBoards[1].Holes[1].PowerbankSN != "85021618" {
+		t.Fatalf("check before popup: %+v", check)
+	}
+
+	popup := handleAndParse(t, c, `{"cmd":"popup_sn","data":"85021618","timestamp":"1759941810","ttl":"30"}`).(*powerbankModels.PowerBankPopupResponse)
+	if popup.State != powerbankModels.PopupSuccess || popup.HoleIndex != 6 || popup.PowerbankSN != "85021618" {
+		t.Errorf("popup reply: %+v", popup)
+	}
+
+	check = handleAndParse(t, c, `{"cmd":"check"}`).(*powerbankModels.PowerBankCheckResponse)
+	if h := check.ControlBoards[1].Holes[1]; h.State != 0 || h.PowerbankSN != "0" {
+		t.Errorf("slot 6 after popup: %+v", h)
+	}
+
+	again := handleAndParse(t, c, `{"cmd":"popup_sn","data":"85021618"}`).(*powerbankModels.PowerBankPopupResponse)
+	if again.GetStatus() != constants.PowerbankStatus_PopupTargetSnNotFound {
+		t.Errorf("second popup: got %v want target-sn-not-found", again.GetStatus())
+	}
+}
+
+func TestCabinetScriptedFaults(t *testing.T) {
+	c := newCabinet(1, 4, defaultSignal)
+	if _, err := c.insert(2, "85021618", 90); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	// FailNext: failure state, bank stays.
+	c.faults[constants.PUBLISH_TYPE_POPUP_BY_HOLE] = []fault{{state: 0x11}}
+	res := handleAndParse(t, c, `{"cmd":"popup","data":"2","io":"0"}`).(*powerbankModels.PowerBankPopupByHoleResponse)
+	if res.State != 0x11 || res.HoleIndex != 2 || res.ControlIndex != 1 {
+		t.Errorf("failed popup reply: %+v", res)
+	}
+	if c.boards[0].Holes[1].State == 0 {
+		t.Errorf("bank ejected despite failure")
+	}
+
+	// DropNext with eject: no reply, bank gone.
+	c.faults[constants.PUBLISH_TYPE_POPUP] = []fault{{drop: true, eject: true}}
+	if res := handleAndParse(t, c, `{"cmd":"popup_sn","data":"85021618"}`); res != nil {
+		t.Errorf("dropped popup replied %+v", res)
+	}
+	if c.boards[0].Holes[1].State != 0 {
+		t.Errorf("bank not ejected on dropped reply")
+	}
+
+	rep, err := c.handle([]byte(`{"cmd":"reboot"}`))
+	if err != nil || rep == nil || rep.topic != replyHeart {
+		t.Fatalf("reboot: rep=%+v err=%v", rep, err)
+	}
+	if heart, err := powerbankUtils.ParseHealthCheckResponse(rep.frame); err != nil || heart.Signal != defaultSignal {
+		t.Errorf("reboot heart: %+v err=%v", heart, err)
+	}
+}
+
+// TestSimulatorBoardLayout checks NewSimulator only accepts layouts a 0x10 frame can
+// carry: a short board must be the only one.
+func TestSimulatorBoardLayout(t *testing.T) {
+	_, err := NewSimulator(powerbankModels.SimulatorInput{DeviceID: "864601068412899", Boards: 2, HolesPerBoard: 2})
+	if err == nil {
+		t.Fatal("2 boards of 2 holes: expected error")
+	}
+
+	c := newCabinet(1, 2, defaultSignal)
+	check := handleAndParse(t, c, `{"cmd":"check"}`).(*powerbankModels.PowerBankCheckResponse)
+	if len(check.ControlBoards) != 1 || len(check.ControlBoards[0].Holes) != 2 {
+		t.Errorf("1 board of 2 holes: %+v", check)
+	}
+}
@@ -0,0 +1,253 @@
+// Package powerbankSimulator is a fake Volinks cabinet that speaks the Powerbank
+// Protocol V1 over MQTT, so dispense flows can be exercised against a local broker in
+// CI with no hardware. It answers the JSON commands the SDK publishes with correctly
+// encoded frames, emits 0x7A heartbeats, and can be scripted to return banks, fail or
+// drop replies, and delay.
+package powerbankSimulator
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/techpartners-asia/powerbank/constants"
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+	powerbankUtils "github.com/techpartners-asia/powerbank/utils"
+)
+
+const defaultSignal = "CSQ:27;BP:0"
+
+// Simulator is one fake cabinet connected to a broker.
+type Simulator struct {
+	input   powerbankModels.SimulatorInput
+	client  mqtt.Client
+	cabinet *cabinet
+
+	mu    sync.Mutex
+	delay time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSimulator connects a cabinet with input.Boards control boards, all slots empty,
+// and starts answering commands. Stock it with Insert before dispensing.
+func NewSimulator(input powerbankModels.SimulatorInput) (*Simulator, error) {
+	if input.DeviceID == "" {
+		return nil, fmt.Errorf("simulator: DeviceID is required")
+	}
+	if input.Boards <= 0 {
+		input.Boards = 1
+	}
+	if input.HolesPerBoard <= 0 {
+		input.HolesPerBoard = 4
+	}
+	if input.HolesPerBoard > 4 {
+		return nil, fmt.Errorf("simulator: HolesPerBoard %d exceeds the 4 a 0x10 frame carries per board", input.HolesPerBoard)
+	}
+	if input.HolesPerBoard < 4 && input.Boards > 1 {
+		// The 0x10 frame has no per-board hole count: only the last board may be short.
+		return nil, fmt.Errorf("simulator: HolesPerBoard %d with %d boards: every board but the last carries 4 holes in a 0x10 frame", input.HolesPerBoard, input.Boards)
+	}
+	if input.HeartbeatInterval == 0 {
+		input.HeartbeatInterval = constants.HEARTBEAT_INTERVAL
+	}
+	if input.Signal == "" {
+		input.Signal = defaultSignal
+	}
+	if input.Topics == (powerbankModels.TopicLayout{}) {
+		input.Topics = powerbankModels.TopicLayout{
+			Publish: constants.TOPIC_TEMPLATE_PUBLISH,
+			Update:  constants.TOPIC_TEMPLATE_UPDATE,
+			Heart:   constants.TOPIC_TEMPLATE_HEART,
+		}
+	}
+	for _, template := range []string{input.Topics.Publish, input.Topics.Update, input.Topics.Heart} {
+		if err := powerbankUtils.ValidateTopicTemplate(template); err != nil {
+			return nil, fmt.Errorf("simulator: %w", err)
+		}
+	}
+
+	s := &Simulator{
+		input:   input,
+		cabinet: newCabinet(input.Boards, input.HolesPerBoard, input.Signal),
+		stop:    make(chan struct{}),
+	}
+
+	onCommand := func(_ mqtt.Client, msg mqtt.Message) {
+		rep, err := s.cabinet.handle(msg.Payload())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[simulator] device=%s: %v\n", input.DeviceID, err)
+			return
+		}
+		if input.Debug {
+			fmt.Printf("[simulator] device=%s cmd=%s reply=%v\n", input.DeviceID, msg.Payload(), rep != nil)
+		}
+		if rep == nil {
+			return
+		}
+		s.mu.Lock()
+		delay := s.delay
+		s.mu.Unlock()
+		// Reply off paho's receive goroutine so a scripted delay does not stall it.
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-s.stop:
+					return
+				}
+			}
+			if err := s.publish(rep.topic, rep.frame); err != nil && !s.closed() {
+				fmt.Fprintf(os.Stderr, "[simulator] device=%s: %v\n", input.DeviceID, err)
+			}
+		}()
+	}
+
+	opts := mqtt.NewClientOptions().AddBroker(fmt.Sprintf("tcp://%s:%s", input.Host, input.Port))
+	opts.SetClientID(input.DeviceID)
+	opts.SetUsername(input.Username)
+	opts.SetPassword(input.Password)
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(5 * time.Second)
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		c.Subscribe(powerbankUtils.FormatTopic(input.Topics.Publish, input.DeviceID), 0, onCommand)
+	})
+
+	s.client = mqtt.NewClient(opts)
+	if token := s.client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("simulator: mqtt connect: %w", token.Error())
+	}
+
+	if input.HeartbeatInterval > 0 {
+		s.wg.Add(1)
+		go s.heartbeatLoop(input.HeartbeatInterval)
+	}
+	return s, nil
+}
+
+func (s *Simulator) heartbeatLoop(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Heartbeat(); err != nil {
+				fmt.Fprintf(os.Stderr, "[simulator] device=%s: %v\n", s.input.DeviceID, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// publish sends frame on the configured layout's update or heart topic.
+func (s *Simulator) publish(topic replyTopic, frame []byte) error {
+	template := s.input.Topics.Update
+	if topic == replyHeart {
+		template = s.input.Topics.Heart
+	}
+	token := s.client.Publish(powerbankUtils.FormatTopic(template, s.input.DeviceID), 0, false, frame)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("mqtt publish: %w", err)
+	}
+	return nil
+}
+
+// Close stops the heartbeat and pending replies and disconnects.
+func (s *Simulator) Close() {
+	close(s.stop)
+	// Disconnect before waiting so paho stops delivering commands that would start
+	// new reply goroutines.
+	s.client.Disconnect(250)
+	s.wg.Wait()
+}
+
+func (s *Simulator) closed() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Insert silently stocks a slot with a healthy bank, as if it had been there at boot.
+func (s *Simulator) Insert(hole int, sn string, soc int) error {
+	s.cabinet.mu.Lock()
+	defer s.cabinet.mu.Unlock()
+	_, err := s.cabinet.insert(hole, sn, soc)
+	return err
+}
+
+// InjectReturn simulates a user returning a bank into a slot: the slot is stocked and a
+// successful 0x40 return frame is published.
+func (s *Simulator) InjectReturn(hole int, sn string, soc int) error {
+	s.cabinet.mu.Lock()
+	cb, err := s.cabinet.insert(hole, sn, soc)
+	s.cabinet.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	frame, err := powerbankUtils.EncodeReturnPowerBankResponse(&powerbankModels.PowerBankReturnResponse{
+		ControlIndex: cb.ControlIndex,
+		HoleIndex:    hole,
+		PowerbankSN:  sn,
+		State:        powerbankModels.ReturnSuccess,
+		SoftVersion:  13,
+		SOC:          soc,
+	})
+	if err != nil {
+		return err
+	}
+	return s.publish(replyUpdate, frame)
+}
+
+// FailNext makes the next command of typ (popup_sn or popup) reply with state instead of
+// success, leaving the bank in its slot.
+func (s *Simulator) FailNext(typ constants.PUBLISH_TYPE, state int) {
+	s.addFault(typ, fault{state: state})
+}
+
+// DropNext makes the next command of typ send no reply. With eject set a popup still
+// releases the bank — the lost-ACK case a dispense workflow must recover from.
+func (s *Simulator) DropNext(typ constants.PUBLISH_TYPE, eject bool) {
+	s.addFault(typ, fault{eject: eject, drop: true})
+}
+
+func (s *Simulator) addFault(typ constants.PUBLISH_TYPE, f fault) {
+	s.cabinet.mu.Lock()
+	defer s.cabinet.mu.Unlock()
+	s.cabinet.faults[typ] = append(s.cabinet.faults[typ], f)
+}
+
+// SetDelay delays every subsequent reply by d.
+func (s *Simulator) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Heartbeat publishes a 0x7A heart frame now, outside the periodic schedule.
+func (s *Simulator) Heartbeat() error {
+	s.cabinet.mu.Lock()
+	frame, err := s.cabinet.heart()
+	s.cabinet.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.publish(replyHeart, frame)
+}
+
+// Snapshot returns the cabinet's current state as the 0x10 check frame would report it.
+func (s *Simulator) Snapshot() *powerbankModels.PowerBankCheckResponse {
+	s.cabinet.mu.Lock()
+	defer s.cabinet.mu.Unlock()
+	return s.cabinet.snapshot()
+}
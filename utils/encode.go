@@ -0,0 +1,198 @@
+package powerbankUtils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// FrameHead is the head code every Volinks frame starts with. Encoders use it when the
+// struct's Head is left zero.
+const FrameHead byte = 0xA8
+
+// frameWriter accumulates a frame and the first field that did not fit, so encoders
+// read as a flat list of fields like the parsers do.
+type frameWriter struct {
+	buf []byte
+	err error
+}
+
+func newFrameWriter(head byte, cmd byte) *frameWriter {
+	if head == 0 {
+		head = FrameHead
+	}
+	// Length (bytes 1-2) is filled in by finish once the body is known.
+	return &frameWriter{buf: []byte{head, 0x00, 0x00, cmd}}
+}
+
+func (w *frameWriter) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+// int writes v as one byte, rejecting values the field cannot carry.
+func (w *frameWriter) int(field string, v int) {
+	if v < 0 || v > 0xFF {
+		w.fail(fmt.Errorf("%s %d does not fit in one byte", field, v))
+	}
+	w.byte(byte(v))
+}
+
+// tenths writes a 0.1-unit value (voltage/current) as one byte.
+func (w *frameWriter) tenths(field string, v float64) {
+	w.int(field, int(math.Round(v*10)))
+}
+
+// sn writes a decimal power bank SN as 4 big-endian bytes. An empty SN is written as 0,
+// which is what the parsers report for an empty slot.
+func (w *frameWriter) sn(v string) {
+	var n uint64
+	if v != "" {
+		var err error
+		if n, err = strconv.ParseUint(v, 10, 32); err != nil {
+			w.fail(fmt.Errorf("powerbank SN %q: %w", v, err))
+		}
+	}
+	w.buf = append(w.buf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func (w *frameWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// finish fills in Length and appends the check code.
+func (w *frameWriter) finish() ([]byte, error) {
+	if w.err != nil {
+		return nil, fmt.Errorf("encode cmd 0x%02X: %w", w.buf[3], w.err)
+	}
+	total := len(w.buf) + 1
+	declared := total
+	if w.buf[3] == 0x40 {
+		// Same quirk frameLen undoes: the 0x40 Length excludes the head byte.
+		declared--
+	}
+	if declared > 0xFFFF {
+		return nil, fmt.Errorf("encode cmd 0x%02X: frame of %d bytes exceeds the Length field", w.buf[3], total)
+	}
+	w.buf[1] = byte(declared >> 8)
+	w.buf[2] = byte(declared)
+	return append(w.buf, CheckCode(w.buf)), nil
+}
+
+// EncodeCheckResponse builds a 0x10 cabinet info frame. Length and the check code are
+// computed; the struct's Length, Cmd and Verify are ignored. Every board but the last
+// must carry exactly 4 holes, since the frame has no per-board hole count.
+func EncodeCheckResponse(res *powerbankModels.PowerBankCheckResponse) ([]byte, error) {
+	w := newFrameWriter(res.Head, 0x10)
+	for i, cb := range res.ControlBoards {
+		if len(cb.Holes) > holesPerBoard || (len(cb.Holes) < holesPerBoard && i < len(res.ControlBoards)-1) {
+			return nil, fmt.Errorf("encode cmd 0x10: board %d has %d holes, want %d", cb.ControlIndex, len(cb.Holes), holesPerBoard)
+		}
+		w.int("ControlIndex", cb.ControlIndex)
+		w.int("Undefined1", cb.Undefined1)
+		w.int("Undefined2", cb.Undefined2)
+		w.int("Temperature", cb.Temperature)
+		w.int("SoftVersion", cb.SoftVersion)
+		w.int("HardVersion", cb.HardVersion)
+		for _, h := range cb.Holes {
+			w.int("HoleIndex", h.HoleIndex)
+			w.int("State", h.State)
+			w.tenths("PowerbankCurr", h.PowerbankCurr)
+			w.tenths("PowerbankVolt", h.PowerbankVolt)
+			w.int("Area", h.Area)
+			w.sn(h.PowerbankSN)
+			w.int("SOC", h.SOC)
+			w.int("Temperature", h.Temperature)
+			w.tenths("ChargeVolt", h.ChargeVolt)
+			w.tenths("ChargeCurr", h.ChargeCurr)
+			w.int("SoftVersion", h.SoftVersion)
+			w.byte(h.Sensor)
+		}
+	}
+	return w.finish()
+}
+
+// EncodePopupPowerBankResponse builds a 0x31 Pop-up By SN frame.
+func EncodePopupPowerBankResponse(res *powerbankModels.PowerBankPopupResponse) ([]byte, error) {
+	w := newFrameWriter(res.Head, 0x31)
+	w.int("HoleIndex", res.HoleIndex)
+	w.sn(res.PowerbankSN)
+	w.int("State", res.State)
+	w.byte(res.Reserved)
+	return w.finish()
+}
+
+// EncodePopupByHolePowerBankResponse builds a 0x21 Pop-up By Hole frame.
+func EncodePopupByHolePowerBankResponse(res *powerbankModels.PowerBankPopupByHoleResponse) ([]byte, error) {
+	w := newFrameWriter(res.Head, 0x21)
+	w.int("ControlIndex", res.ControlIndex)
+	w.int("HoleIndex", res.HoleIndex)
+	w.int("State", res.State)
+	w.byte(res.Reserved)
+	return w.finish()
+}
+
+// EncodeReturnPowerBankResponse builds a 0x40 Return frame.
+func EncodeReturnPowerBankResponse(res *powerbankModels.PowerBankReturnResponse) ([]byte, error) {
+	w := newFrameWriter(res.Head, 0x40)
+	w.int("ControlIndex", res.ControlIndex)
+	w.int("HoleIndex", res.HoleIndex)
+	w.int("Area", res.Area)
+	w.sn(res.PowerbankSN)
+	w.int("State", res.State)
+	w.int("SoftVersion", res.SoftVersion)
+	w.int("SOC", res.SOC)
+	return w.finish()
+}
+
+// EncodeReturnFixPowerBankResponse builds a 0x28 Return-Fix frame.
+func EncodeReturnFixPowerBankResponse(res *powerbankModels.PowerBankReturnFixResponse) ([]byte, error) {
+	w := newFrameWriter(res.Head, 0x28)
+	w.int("ControlIndex", res.ControlIndex)
+	w.int("HoleIndex", res.HoleIndex)
+	w.int("State", res.State)
+	w.byte(res.Reserved1)
+	w.byte(res.Reserved2)
+	w.int("Area", res.Area)
+	w.sn(res.PowerbankSN)
+	w.int("SOC", res.SOC)
+	w.int("Temperature", res.Temperature)
+	w.tenths("ChargeVolt", res.ChargeVolt)
+	w.tenths("ChargeCurr", res.ChargeCurr)
+	w.int("SoftVersion", res.SoftVersion)
+	w.int("HardVersion", res.HardVersion)
+	return w.finish()
+}
+
+// EncodeHealthCheckResponse builds a 0x7A heart frame; Signal is written as-is
+// (e.g. "CSQ:27;BP:0").
+func EncodeHealthCheckResponse(res *powerbankModels.PowerBankHealthCheckResponse) ([]byte, error) {
+	w := newFrameWriter(res.Head, 0x7A)
+	w.int("ControlIndex", res.ControlIndex)
+	w.buf = append(w.buf, res.Signal...)
+	return w.finish()
+}
+
+// EncodeResponse is the inverse of ParseResponse (plus the 0x7A heart frame): it
+// encodes any response struct the parsers return.
+func EncodeResponse(res interface{}) ([]byte, error) {
+	switch r := res.(type) {
+	case *powerbankModels.PowerBankCheckResponse:
+		return EncodeCheckResponse(r)
+	case *powerbankModels.PowerBankPopupResponse:
+		return EncodePopupPowerBankResponse(r)
+	case *powerbankModels.PowerBankPopupByHoleResponse:
+		return EncodePopupByHolePowerBankResponse(r)
+	case *powerbankModels.PowerBankReturnResponse:
+		return EncodeReturnPowerBankResponse(r)
+	case *powerbankModels.PowerBankReturnFixResponse:
+		return EncodeReturnFixPowerBankResponse(r)
+	case *powerbankModels.PowerBankHealthCheckResponse:
+		return EncodeHealthCheckResponse(r)
+	default:
+		return nil, fmt.Errorf("encode: unsupported response type %T", res)
+	}
+}
@@ -0,0 +1,102 @@
+package powerbankUtils
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	powerbankModels "github.com/techpartners-asia/powerbank/models"
+)
+
+// TestEncodeDocFramesByteExact parses each doc example and re-encodes it; the encoder
+// must reproduce the original bytes, Length and check code included.
+func TestEncodeDocFramesByteExact(t *testing.T) {
+	frames := []string{
+		"A8 00 0C 31 60 00 9B D2 10 01 00 3D",
+		"A8 00 11 7A 10 43 53 51 3A 32 37 3B 42 50 3A 30 FC",
+		"A8 00 89 10 01 FF FF 00 04 16 01 01 00 EC 00 05 11 49 F1 64 1F 32 01 0D 00 02 00 00 00 00 00 00 00 00 00 00 00 00 00 80 03 01 00 E8 00 05 11 46 AC 64 20 32 00 0D 00 04 00 00 00 00 00 00 00 00 00 00 00 00 00 80 02 FF FF 00 04 16 05 01 00 D7 00 04 C6 F0 96 64 1F 32 00 1A 00 06 00 00 00 00 00 00 00 00 00 00 00 00 00 80 07 01 00 E9 00 05 11 49 DB 64 1E 32 00 0D 00 08 00 00 00 00 00 00 00 00 00 00 00 00 00 80 D8",
+	}
+	for _, f := range frames {
+		want := fromHex(t, f)
+		var res interface{}
+		var err error
+		if want[3] == 0x7A {
+			res, err = ParseHealthCheckResponse(want)
+		} else {
+			_, res, err = ParseResponse(want)
+		}
+		if err != nil {
+			t.Fatalf("cmd %#x: parse: %v", want[3], err)
+		}
+		got, err := EncodeResponse(res)
+		if err != nil {
+			t.Fatalf("cmd %#x: encode: %v", want[3], err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("cmd %#x: round trip\n got: % X\nwant: % X", want[3], got, want)
+		}
+	}
+}
+
+// TestEncodeRoundTrip encodes structs built in code and expects the parsers to return
+// them unchanged apart from the computed Head, Length, Cmd and Verify.
+func TestEncodeRoundTrip(t *testing.T) {
+	cases := []struct {
+		name  string
+		in    interface{}
+		parse func([]byte) (interface{}, error)
+	}{
+		{
+			"popup_hole(0x21)",
+			&powerbankModels.PowerBankPopupByHoleResponse{ControlIndex: 1, HoleIndex: 5, State: 0x01},
+			func(b []byte) (interface{}, error) { return ParsePopupByHolePowerBankResponse(b) },
+		},
+		{
+			"return(0x40)",
+			&powerbankModels.PowerBankReturnResponse{ControlIndex: 1, HoleIndex: 5, PowerbankSN: "85019121", State: 0x01, SoftVersion: 13, SOC: 100},
+			func(b []byte) (interface{}, error) { return ParseReturnPowerBankResponse(b) },
+		},
+		{
+			"return_fix(0x28)",
+			&powerbankModels.PowerBankReturnFixResponse{ControlIndex: 1, HoleIndex: 5, State: 0x24, PowerbankSN: "85019121", SOC: 100, Temperature: 31, ChargeVolt: 5.0, ChargeCurr: 0.1, SoftVersion: 4, HardVersion: 1},
+			func(b []byte) (interface{}, error) { return ParseReturnFixPowerBankResponse(b) },
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := EncodeResponse(tc.in)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			got, err := tc.parse(b)
+			if err != nil {
+				t.Fatalf("parse % X: %v", b, err)
+			}
+			// Copy the computed header fields over so the rest must match exactly.
+			want := reflect.New(reflect.TypeOf(tc.in).Elem())
+			want.Elem().Set(reflect.ValueOf(tc.in).Elem())
+			for _, f := range []string{"Head", "Length", "Cmd", "Verify"} {
+				want.Elem().FieldByName(f).Set(reflect.ValueOf(got).Elem().FieldByName(f))
+			}
+			if !reflect.DeepEqual(got, want.Interface()) {
+				t.Errorf("round trip:\n got: %+v\nwant: %+v", got, want.Interface())
+			}
+		})
+	}
+}
+
+func TestEncodeRejectsUnencodableFields(t *testing.T) {
+	if _, err := EncodePopupPowerBankResponse(&powerbankModels.PowerBankPopupResponse{PowerbankSN: "not-a-number"}); err == nil {
+		t.Errorf("non-numeric SN: expected error")
+	}
+	if _, err := EncodePopupByHolePowerBankResponse(&powerbankModels.PowerBankPopupByHoleResponse{HoleIndex: 256}); err == nil {
+		t.Errorf("hole 256: expected error")
+	}
+	short := &powerbankModels.PowerBankCheckResponse{ControlBoards: []powerbankModels.ControlBoard{
+		{ControlIndex: 1, Holes: make([]powerbankModels.Hole, 2)},
+		{ControlIndex: 2, Holes: make([]powerbankModels.Hole, 4)},
+	}}
+	if _, err := EncodeCheckResponse(short); err == nil {
+		t.Errorf("non-final board with 2 holes: expected error")
+	}
+}
@@ -0,0 +1,90 @@
+package powerbankUtils
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Frame-length errors, returned (wrapped) by every parser. A frame shorter than its
+// Length field (or than its command's fixed layout) is truncated; one longer is
+// oversized. Test with errors.Is.
+var (
+	ErrFrameTruncated = errors.New("frame truncated")
+	ErrFrameOversized = errors.New("frame oversized")
+)
+
+// ErrChecksumMismatch is returned (wrapped) by every parser when a frame's trailing
+// check code does not match its contents. Test with errors.Is.
+var ErrChecksumMismatch = errors.New("check code mismatch")
+
+// ErrUnknownCommand is returned (wrapped) by ParseResponse for a well-formed frame whose
+// cmd byte it has no parser for, e.g. from newer firmware. Test with errors.Is.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Parser decodes cabinet frames. Its zero value is what the package-level Parse
+// functions use: every check code must verify.
+type Parser struct {
+	// LenientChecksum accepts frames whose check code does not verify, for cabinets
+	// with known-bad firmware. NewServer sets it from ServerInput.LenientChecksum.
+	LenientChecksum bool
+}
+
+// CheckCode computes the Volinks check code for a frame body (every byte before the
+// check code, head included): the two's complement of the byte sum, so that all bytes
+// of a valid frame sum to zero mod 256.
+func CheckCode(body []byte) byte {
+	var sum byte
+	for _, b := range body {
+		sum += b
+	}
+	return -sum
+}
+
+// frameLen returns the total frame size declared by the Length field (bytes 1-2). The
+// 0x40 return frame is the one known quirk: its Length excludes the head byte.
+func frameLen(frame []byte) int {
+	declared := int(frame[1])<<8 | int(frame[2])
+	if frame[3] == 0x40 {
+		return declared + 1
+	}
+	return declared
+}
+
+// verifyFrame runs the checks every parser shares on an untrusted frame: at least
+// minLen bytes, a Length field matching the bytes received, and a valid check code.
+func (p Parser) verifyFrame(frame []byte, minLen int) error {
+	if len(frame) < minLen {
+		return fmt.Errorf("%w: expected at least %d bytes, got %d", ErrFrameTruncated, minLen, len(frame))
+	}
+	if want := frameLen(frame); len(frame) < want {
+		return fmt.Errorf("%w: cmd 0x%02X declares %d bytes, got %d", ErrFrameTruncated, frame[3], want, len(frame))
+	} else if len(frame) > want {
+		return fmt.Errorf("%w: cmd 0x%02X declares %d bytes, got %d", ErrFrameOversized, frame[3], want, len(frame))
+	}
+	return p.verifyCheckCode(frame)
+}
+
+// verifyFixedFrame is verifyFrame for commands with a fixed layout of size bytes.
+func (p Parser) verifyFixedFrame(frame []byte, size int) error {
+	if len(frame) > size {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrFrameOversized, size, len(frame))
+	}
+	return p.verifyFrame(frame, size)
+}
+
+// verifyCheckCode checks the last byte of frame against CheckCode of the rest.
+func (p Parser) verifyCheckCode(frame []byte) error {
+	if len(frame) < 4 {
+		return fmt.Errorf("invalid data length: expected at least 4 bytes, got %d", len(frame))
+	}
+	got := frame[len(frame)-1]
+	want := CheckCode(frame[:len(frame)-1])
+	if got == want {
+		return nil
+	}
+	if p.LenientChecksum {
+		debugf("Accepting cmd 0x%02X with bad check code 0x%02X (want 0x%02X): lenient mode\n", frame[3], got, want)
+		return nil
+	}
+	return fmt.Errorf("%w: cmd 0x%02X got 0x%02X, want 0x%02X", ErrChecksumMismatch, frame[3], got, want)
+}
@@ -10,13 +10,13 @@ import (
 
 // ParsePowerBankUploadResponse parses the upload_all (0x10) cabinet info frame.
 // The layout is identical to the check response, so it delegates to ParseCheckResponse.
-func ParsePowerBankUploadResponse(data []byte) (*powerbankModels.PowerBankUploadResponse, error) {
-	return ParseCheckResponse(data)
+func (p Parser) ParsePowerBankUploadResponse(data []byte) (*powerbankModels.PowerBankUploadResponse, error) {
+	return p.ParseCheckResponse(data)
 }
 
-func ParseReturnPowerBankResponse(response []byte) (*powerbankModels.PowerBankReturnResponse, error) {
-	if len(response) < 15 {
-		return nil, fmt.Errorf("invalid data length: expected at least 15 bytes, got %d", len(response))
+func (p Parser) ParseReturnPowerBankResponse(response []byte) (*powerbankModels.PowerBankReturnResponse, error) {
+	if err := p.verifyFixedFrame(response, 15); err != nil {
+		return nil, err
 	}
 
 	return &powerbankModels.PowerBankReturnResponse{
@@ -33,9 +33,10 @@ func ParseReturnPowerBankResponse(response []byte) (*powerbankModels.PowerBankRe
 		Verify:       response[14],
 	}, nil
 }
-func ParseReturnFixPowerBankResponse(response []byte) (*powerbankModels.PowerBankReturnFixResponse, error) {
-	if len(response) < 21 {
-		return nil, fmt.Errorf("invalid data length: expected at least 21 bytes, got %d", len(response))
+
+func (p Parser) ParseReturnFixPowerBankResponse(response []byte) (*powerbankModels.PowerBankReturnFixResponse, error) {
+	if err := p.verifyFixedFrame(response, 21); err != nil {
+		return nil, err
 	}
 
 	return &powerbankModels.PowerBankReturnFixResponse{
@@ -59,9 +60,9 @@ func ParseReturnFixPowerBankResponse(response []byte) (*powerbankModels.PowerBan
 	}, nil
 }
 
-func ParsePopupByHolePowerBankResponse(response []byte) (*powerbankModels.PowerBankPopupByHoleResponse, error) {
-	if len(response) < 9 {
-		return nil, fmt.Errorf("invalid data length: expected at least 9 bytes, got %d", len(response))
+func (p Parser) ParsePopupByHolePowerBankResponse(response []byte) (*powerbankModels.PowerBankPopupByHoleResponse, error) {
+	if err := p.verifyFixedFrame(response, 9); err != nil {
+		return nil, err
 	}
 
 	return &powerbankModels.PowerBankPopupByHoleResponse{
@@ -76,12 +77,12 @@ func ParsePopupByHolePowerBankResponse(response []byte) (*powerbankModels.PowerB
 	}, nil
 }
 
-func ParsePopupPowerBankResponse(response []byte) (*powerbankModels.PowerBankPopupResponse, error) {
+func (p Parser) ParsePopupPowerBankResponse(response []byte) (*powerbankModels.PowerBankPopupResponse, error) {
 	// The 0x31 popup_sn frame is 12 bytes; this reads up to response[11] (Verify).
 	// Guarding on 9 (the old value) let a 9–11 byte frame panic with index-out-of-range
 	// on the dispense-ACK path.
-	if len(response) < 12 {
-		return nil, fmt.Errorf("invalid data length: expected at least 12 bytes, got %d", len(response))
+	if err := p.verifyFixedFrame(response, 12); err != nil {
+		return nil, err
 	}
 
 	return &powerbankModels.PowerBankPopupResponse{
@@ -95,9 +96,14 @@ func ParsePopupPowerBankResponse(response []byte) (*powerbankModels.PowerBankPop
 		Verify:      response[11],
 	}, nil
 }
-func ParseCheckResponse(response []byte) (*powerbankModels.PowerBankCheckResponse, error) {
-	if len(response) < 5 {
-		return nil, fmt.Errorf("invalid data length: expected at least 5 bytes, got %d", len(response))
+
+// holesPerBoard is the number of hole records that follow each control board record
+// in a 0x10 frame.
+const holesPerBoard = 4
+
+func (p Parser) ParseCheckResponse(response []byte) (*powerbankModels.PowerBankCheckResponse, error) {
+	if err := p.verifyFrame(response, 5); err != nil {
+		return nil, err
 	}
 
 	resp := &powerbankModels.PowerBankCheckResponse{
@@ -129,7 +135,7 @@ func ParseCheckResponse(response []byte) (*powerbankModels.PowerBankCheckRespons
 		holes := make([]powerbankModels.Hole, 0)
 
 		// Parse holes for this control board (4 holes per control board, 15 bytes each)
-		for i := 0; i < 4 && pos+15 <= len(response)-1; i++ {
+		for i := 0; i < holesPerBoard && pos+15 <= len(response)-1; i++ {
 			h := powerbankModels.Hole{
 				HoleIndex:     int(response[pos]),
 				State:         int(response[pos+1]),
@@ -164,9 +170,9 @@ func ParseCheckResponse(response []byte) (*powerbankModels.PowerBankCheckRespons
 	return resp, nil
 }
 
-func ParseHealthCheckResponse(response []byte) (*powerbankModels.PowerBankHealthCheckResponse, error) {
-	if len(response) < 9 {
-		return nil, fmt.Errorf("invalid data length: expected at least 9 bytes, got %d", len(response))
+func (p Parser) ParseHealthCheckResponse(response []byte) (*powerbankModels.PowerBankHealthCheckResponse, error) {
+	if err := p.verifyFrame(response, 9); err != nil {
+		return nil, err
 	}
 
 	return &powerbankModels.PowerBankHealthCheckResponse{
@@ -190,46 +196,82 @@ func debugf(format string, args ...interface{}) {
 	}
 }
 
-func ParseResponse(payload []byte) (constants.PUBLISH_TYPE, interface{}, error) {
-	if len(payload) < 4 {
-		return "", nil, fmt.Errorf("invalid response length: expected at least 4 bytes, got %d", len(payload))
-	}
-
+func (p Parser) ParseResponse(payload []byte) (constants.PUBLISH_TYPE, interface{}, error) {
 	debugf("Payload: % X\n", payload)
 
+	// Reject truncated and corrupted frames up front so a damaged cmd byte is reported
+	// as such rather than as an unknown command. Each parser re-verifies for direct callers.
+	if err := p.verifyFrame(payload, 4); err != nil {
+		return "", nil, err
+	}
+
 	cmd := payload[3]
 	switch cmd {
 	case 0x10:
-		response, err := ParseCheckResponse(payload)
+		response, err := p.ParseCheckResponse(payload)
 		if err != nil {
 			return "", nil, fmt.Errorf("parse check (0x10): %w", err)
 		}
 		return constants.PUBLISH_TYPE_CHECK, response, nil
 	case 0x31:
-		response, err := ParsePopupPowerBankResponse(payload)
+		response, err := p.ParsePopupPowerBankResponse(payload)
 		if err != nil {
 			return "", nil, fmt.Errorf("parse popup (0x31): %w", err)
 		}
 		return constants.PUBLISH_TYPE_POPUP, response, nil
 	case 0x21:
-		response, err := ParsePopupByHolePowerBankResponse(payload)
+		response, err := p.ParsePopupByHolePowerBankResponse(payload)
 		if err != nil {
 			return "", nil, fmt.Errorf("parse popup-by-hole (0x21): %w", err)
 		}
 		return constants.PUBLISH_TYPE_POPUP_BY_HOLE, response, nil
 	case 0x40:
-		response, err := ParseReturnPowerBankResponse(payload)
+		response, err := p.ParseReturnPowerBankResponse(payload)
 		if err != nil {
 			return "", nil, fmt.Errorf("parse return (0x40): %w", err)
 		}
 		return constants.PUBLISH_TYPE_RETURN, response, nil
 	case 0x28:
-		response, err := ParseReturnFixPowerBankResponse(payload)
+		response, err := p.ParseReturnFixPowerBankResponse(payload)
 		if err != nil {
 			return "", nil, fmt.Errorf("parse return-fix (0x28): %w", err)
 		}
 		return constants.PUBLISH_TYPE_RETURN_FIX, response, nil
 	default:
-		return "", nil, fmt.Errorf("unknown command type: 0x%02X", cmd)
+		return "", nil, fmt.Errorf("command type 0x%02X: %w", cmd, ErrUnknownCommand)
 	}
 }
+
+// The package-level parsers decode with the zero Parser, verifying every check code.
+
+func ParsePowerBankUploadResponse(data []byte) (*powerbankModels.PowerBankUploadResponse, error) {
+	return Parser{}.ParsePowerBankUploadResponse(data)
+}
+
+func ParseReturnPowerBankResponse(response []byte) (*powerbankModels.PowerBankReturnResponse, error) {
+	return Parser{}.ParseReturnPowerBankResponse(response)
+}
+
+func ParseReturnFixPowerBankResponse(response []byte) (*powerbankModels.PowerBankReturnFixResponse, error) {
+	return Parser{}.ParseReturnFixPowerBankResponse(response)
+}
+
+func ParsePopupByHolePowerBankResponse(response []byte) (*powerbankModels.PowerBankPopupByHoleResponse, error) {
+	return Parser{}.ParsePopupByHolePowerBankResponse(response)
+}
+
+func ParsePopupPowerBankResponse(response []byte) (*powerbankModels.PowerBankPopupResponse, error) {
+	return Parser{}.ParsePopupPowerBankResponse(response)
+}
+
+func ParseCheckResponse(response []byte) (*powerbankModels.PowerBankCheckResponse, error) {
+	return Parser{}.ParseCheckResponse(response)
+}
+
+func ParseHealthCheckResponse(response []byte) (*powerbankModels.PowerBankHealthCheckResponse, error) {
+	return Parser{}.ParseHealthCheckResponse(response)
+}
+
+func ParseResponse(payload []byte) (constants.PUBLISH_TYPE, interface{}, error) {
+	return Parser{}.ParseResponse(payload)
+}
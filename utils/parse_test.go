@@ -2,6 +2,7 @@ package powerbankUtils
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -21,9 +22,10 @@ func fromHex(t *testing.T, s string) []byte {
 }
 
 // TestParsePopupPowerBankResponse uses the example frame from
-// docs.volinks.com/powerbank-protocol-v1/en/guide/protocol-popupsn.html
+// docs.volinks.com/powerbank-protocol-v1/en/guide/protocol-popupsn.html, with the
+// check code corrected: the doc prints 0x3B, but the bytes only verify with 0x3D.
 func TestParsePopupPowerBankResponse(t *testing.T) {
-	payload := fromHex(t, "A8 00 0C 31 60 00 9B D2 10 01 00 3B")
+	payload := fromHex(t, "A8 00 0C 31 60 00 9B D2 10 01 00 3D")
 
 	got, err := ParsePopupPowerBankResponse(payload)
 	if err != nil {
@@ -38,7 +40,7 @@ func TestParsePopupPowerBankResponse(t *testing.T) {
 		PowerbankSN: "10211856",
 		State:       0x01,
 		Reserved:    0x00,
-		Verify:      0x3B,
+		Verify:      0x3D,
 	}
 
 	if *got != *want {
@@ -106,7 +108,7 @@ func TestParseResponseDispatch(t *testing.T) {
 		hex     string
 		wantTyp constants.PUBLISH_TYPE
 	}{
-		{"popup_sn", "A8 00 0C 31 60 00 9B D2 10 01 00 3B", constants.PUBLISH_TYPE_POPUP},
+		{"popup_sn", "A8 00 0C 31 60 00 9B D2 10 01 00 3D", constants.PUBLISH_TYPE_POPUP},
 	}
 
 	for _, tc := range cases {
@@ -195,9 +197,9 @@ func TestParseResponseShortPayload(t *testing.T) {
 }
 
 func TestParseResponseUnknownCommand(t *testing.T) {
-	// Valid header but unknown cmd byte 0xAB.
-	if _, _, err := ParseResponse([]byte{0xA8, 0x00, 0x05, 0xAB, 0x00}); err == nil {
-		t.Errorf("expected error for unknown cmd")
+	// Valid header and check code but unknown cmd byte 0xAB.
+	if _, _, err := ParseResponse([]byte{0xA8, 0x00, 0x05, 0xAB, 0xA8}); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("got %v, want ErrUnknownCommand", err)
 	}
 }
 
@@ -205,7 +207,7 @@ func TestParseResponseUnknownCommand(t *testing.T) {
 // dispense-ACK parser: it reads up to response[11], so a 9–11 byte frame (which the
 // old len<9 guard let through) must now return an error rather than index-panic.
 func TestParsePopupResponseRejectsShortFrame(t *testing.T) {
-	full := fromHex(t, "A8 00 0C 31 60 00 9B D2 10 01 00 3B") // 12 bytes, valid
+	full := fromHex(t, "A8 00 0C 31 60 00 9B D2 10 01 00 3D") // 12 bytes, valid
 	for _, n := range []int{0, 4, 9, 10, 11} {
 		if _, err := ParsePopupPowerBankResponse(full[:n]); err == nil {
 			t.Errorf("len %d: expected error, got nil", n)
@@ -225,7 +227,7 @@ func TestParsersNeverPanicOnTruncation(t *testing.T) {
 		frame string
 		call  func([]byte)
 	}{
-		{"popup_sn(0x31)", "A8 00 0C 31 60 00 9B D2 10 01 00 3B", func(b []byte) { _, _ = ParsePopupPowerBankResponse(b) }},
+		{"popup_sn(0x31)", "A8 00 0C 31 60 00 9B D2 10 01 00 3D", func(b []byte) { _, _ = ParsePopupPowerBankResponse(b) }},
 		{"popup_hole(0x21)", "A8 00 09 21 01 05 01 00 2D", func(b []byte) { _, _ = ParsePopupByHolePowerBankResponse(b) }},
 		{"return(0x40)", "A8 00 0E 40 01 05 00 05 11 49 F1 01 0D 64 2A", func(b []byte) { _, _ = ParseReturnPowerBankResponse(b) }},
 		{"return_fix(0x28)", "A8 00 15 28 01 05 01 00 00 00 05 11 49 F1 64 1F 32 01 04 00 7E", func(b []byte) { _, _ = ParseReturnFixPowerBankResponse(b) }},
@@ -247,3 +249,83 @@ func TestParsersNeverPanicOnTruncation(t *testing.T) {
 		}
 	}
 }
+
+// TestCheckCodeDocExamples locks the check code algorithm against the doc frames whose
+// printed check codes are consistent (check and heart).
+func TestCheckCodeDocExamples(t *testing.T) {
+	frames := []string{
+		"A8 00 11 7A 10 43 53 51 3A 32 37 3B 42 50 3A 30 FC",
+		"A8 00 89 10 01 FF FF 00 04 16 01 01 00 EC 00 05 11 49 F1 64 1F 32 01 0D 00 02 00 00 00 00 00 00 00 00 00 00 00 00 00 80 03 01 00 E8 00 05 11 46 AC 64 20 32 00 0D 00 04 00 00 00 00 00 00 00 00 00 00 00 00 00 80 02 FF FF 00 04 16 05 01 00 D7 00 04 C6 F0 96 64 1F 32 00 1A 00 06 00 00 00 00 00 00 00 00 00 00 00 00 00 80 07 01 00 E9 00 05 11 49 DB 64 1E 32 00 0D 00 08 00 00 00 00 00 00 00 00 00 00 00 00 00 80 D8",
+	}
+	for _, f := range frames {
+		b := fromHex(t, f)
+		if got, want := CheckCode(b[:len(b)-1]), b[len(b)-1]; got != want {
+			t.Errorf("cmd %#x: CheckCode got %#x want %#x", b[3], got, want)
+		}
+	}
+}
+
+// TestParsersRejectBadCheckCode flips the check code of a valid frame and expects every
+// parser to return ErrChecksumMismatch, and to accept it again in lenient mode.
+func TestParsersRejectBadCheckCode(t *testing.T) {
+	parsers := []struct {
+		name  string
+		frame string
+		call  func(Parser, []byte) error
+	}{
+		{"popup_sn(0x31)", "A8 00 0C 31 60 00 9B D2 10 01 00 3D", func(p Parser, b []byte) error { _, err := p.ParsePopupPowerBankResponse(b); return err }},
+		{"heart(0x7A)", "A8 00 11 7A 10 43 53 51 3A 32 37 3B 42 50 3A 30 FC", func(p Parser, b []byte) error { _, err := p.ParseHealthCheckResponse(b); return err }},
+		{"dispatch", "A8 00 0C 31 60 00 9B D2 10 01 00 3D", func(p Parser, b []byte) error { _, _, err := p.ParseResponse(b); return err }},
+	}
+	for _, p := range parsers {
+		t.Run(p.name, func(t *testing.T) {
+			b := fromHex(t, p.frame)
+			if err := p.call(Parser{}, b); err != nil {
+				t.Fatalf("valid frame: %v", err)
+			}
+			b[len(b)-1] ^= 0xFF
+			if err := p.call(Parser{}, b); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("corrupted frame: got %v want ErrChecksumMismatch", err)
+			}
+			if _, _, err := ParseResponse(b); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("corrupted frame, package-level: got %v want ErrChecksumMismatch", err)
+			}
+			if err := p.call(Parser{LenientChecksum: true}, b); err != nil {
+				t.Errorf("corrupted frame in lenient mode: %v", err)
+			}
+		})
+	}
+}
+
+// TestParsersValidateLength checks the Length field against the bytes received: a
+// dropped tail is ErrFrameTruncated (so a partial 0x10 snapshot never parses into fewer
+// boards), trailing bytes are ErrFrameOversized, and the 0x40 header quirk is honoured.
+func TestParsersValidateLength(t *testing.T) {
+	check := fromHex(t, "A8 00 89 10 01 FF FF 00 04 16 01 01 00 EC 00 05 11 49 F1 64 1F 32 01 0D 00 02 00 00 00 00 00 00 00 00 00 00 00 00 00 80 03 01 00 E8 00 05 11 46 AC 64 20 32 00 0D 00 04 00 00 00 00 00 00 00 00 00 00 00 00 00 80 02 FF FF 00 04 16 05 01 00 D7 00 04 C6 F0 96 64 1F 32 00 1A 00 06 00 00 00 00 00 00 00 00 00 00 00 00 00 80 07 01 00 E9 00 05 11 49 DB 64 1E 32 00 0D 00 08 00 00 00 00 00 00 00 00 00 00 00 00 00 80 D8")
+
+	// First board only, re-terminated with a check code that verifies: only Length
+	// can tell this apart from a one-board cabinet.
+	partial := append([]byte{}, check[:70]...)
+	partial = append(partial, CheckCode(partial))
+	if _, err := ParseCheckResponse(partial); !errors.Is(err, ErrFrameTruncated) {
+		t.Errorf("partial check: got %v want ErrFrameTruncated", err)
+	}
+	if _, _, err := ParseResponse(partial); !errors.Is(err, ErrFrameTruncated) {
+		t.Errorf("partial check via ParseResponse: got %v want ErrFrameTruncated", err)
+	}
+
+	popup := fromHex(t, "A8 00 0C 31 60 00 9B D2 10 01 00 3D")
+	if _, err := ParsePopupPowerBankResponse(popup[:11]); !errors.Is(err, ErrFrameTruncated) {
+		t.Errorf("short popup: got %v want ErrFrameTruncated", err)
+	}
+	if _, err := ParsePopupPowerBankResponse(append(popup, 0x00)); !errors.Is(err, ErrFrameOversized) {
+		t.Errorf("long popup: got %v want ErrFrameOversized", err)
+	}
+
+	// 0x40 declares 0x000E = 14: the frame is 15 bytes because Length excludes the head.
+	ret := fromHex(t, "A8 00 0E 40 01 05 00 05 11 49 F1 01 0D 64")
+	ret = append(ret, CheckCode(ret))
+	if _, err := ParseReturnPowerBankResponse(ret); err != nil {
+		t.Errorf("return: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package powerbankUtils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/techpartners-asia/powerbank/constants"
+)
+
+// ValidateTopicTemplate checks that template has exactly one {device} placeholder
+// filling a whole topic level and no MQTT wildcards.
+func ValidateTopicTemplate(template string) error {
+	found := 0
+	for _, level := range strings.Split(template, "/") {
+		switch {
+		case level == constants.TOPIC_DEVICE_PLACEHOLDER:
+			found++
+		case strings.Contains(level, constants.TOPIC_DEVICE_PLACEHOLDER):
+			return fmt.Errorf("topic template %q: %s must fill a whole topic level", template, constants.TOPIC_DEVICE_PLACEHOLDER)
+		case strings.ContainsAny(level, "+#"):
+			return fmt.Errorf("topic template %q: wildcards are not allowed", template)
+		}
+	}
+	if found != 1 {
+		return fmt.Errorf("topic template %q: want exactly one %s, found %d", template, constants.TOPIC_DEVICE_PLACEHOLDER, found)
+	}
+	return nil
+}
+
+// FormatTopic returns template's topic for one device.
+func FormatTopic(template, deviceID string) string {
+	return strings.Replace(template, constants.TOPIC_DEVICE_PLACEHOLDER, deviceID, 1)
+}
+
+// TopicFilter returns the subscription filter matching template for every device.
+func TopicFilter(template string) string {
+	return FormatTopic(template, "+")
+}
+
+// TopicDeviceID extracts the device ID from a topic published under template. It
+// reports false when topic does not match template or the device level is empty.
+func TopicDeviceID(template, topic string) (string, bool) {
+	want := strings.Split(template, "/")
+	got := strings.Split(topic, "/")
+	if len(want) != len(got) {
+		return "", false
+	}
+	deviceID := ""
+	for i, level := range want {
+		if level == constants.TOPIC_DEVICE_PLACEHOLDER {
+			deviceID = got[i]
+		} else if level != got[i] {
+			return "", false
+		}
+	}
+	return deviceID, deviceID != ""
+}
@@ -0,0 +1,37 @@
+package powerbankUtils
+
+import (
+	"testing"
+
+	"github.com/techpartners-asia/powerbank/constants"
+)
+
+func TestTopicTemplates(t *testing.T) {
+	const template = "/acme/powerbank/{device}/user/update"
+	if err := ValidateTopicTemplate(template); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if got := TopicFilter(template); got != "/acme/powerbank/+/user/update" {
+		t.Errorf("filter: %s", got)
+	}
+	if got := FormatTopic(constants.TOPIC_TEMPLATE_PUBLISH, "864601068412899"); got != "/powerbank/864601068412899/user/get" {
+		t.Errorf("format: %s", got)
+	}
+
+	for topic, want := range map[string]string{
+		"/acme/powerbank/864601068412899/user/update":  "864601068412899",
+		"/acme/powerbank//user/update":                 "",
+		"/other/powerbank/864601068412899/user/update": "",
+		"/acme/powerbank/864601068412899/user/heart":   "",
+	} {
+		if got, ok := TopicDeviceID(template, topic); got != want || ok != (want != "") {
+			t.Errorf("TopicDeviceID(%s) = %q, %v; want %q", topic, got, ok, want)
+		}
+	}
+
+	for _, bad := range []string{"/powerbank/+/{device}", "/powerbank/{device}/{device}", "/powerbank/dev{device}/get", "/powerbank/user/get"} {
+		if ValidateTopicTemplate(bad) == nil {
+			t.Errorf("ValidateTopicTemplate(%s): expected error", bad)
+		}
+	}
+}